@@ -0,0 +1,202 @@
+//Package analyzer computes the dependency leaks of checked out repositories
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/depbleed/backend/persistence"
+	depbleed "github.com/depbleed/go/go-depbleed"
+)
+
+//Result is the outcome of the analysis of a package
+type Result struct {
+	Leaks []*persistence.Leak `json:"leaks"`
+	//Exported is the number of exported identifiers of the package
+	Exported int `json:"exported"`
+}
+
+//Run computes the leaks of the package in dir, which must be in gopath.
+//The root directory the repositories are checked out in is stripped from
+//the file names and the messages of the leaks.
+//The package is type-checked for each of builds, skipping the ones its
+//files are all excluded from, and the leaks record the builds they are
+//found in. It is type-checked for build.Default when there are none.
+func Run(gopath string, dir string, root string, builds []Build) (Result, error) {
+	if len(builds) == 0 {
+		result, _, err := run(gopath, dir, root)
+		return result, err
+	}
+
+	//The loader type-checks for build.Default
+	defaults := build.Default
+	defer func() {
+		build.Default = defaults
+	}()
+
+	result := Result{Leaks: []*persistence.Leak{}}
+	leaks := map[string]*persistence.Leak{}
+	exported := map[string]bool{}
+	analysed := 0
+	var excluded error
+	for _, b := range builds {
+		build.Default.GOOS, build.Default.GOARCH, build.Default.BuildTags = b.GOOS, b.GOARCH, b.Tags
+
+		buildResult, buildExported, err := run(gopath, dir, root)
+		if _, ok := err.(*build.NoGoError); ok {
+			excluded = err
+			continue
+		} else if err != nil {
+			return Result{}, fmt.Errorf("%s: %s", b, err)
+		}
+		analysed++
+
+		for _, leak := range buildResult.Leaks {
+			key := fmt.Sprintf("%s:%d:%d: %s", leak.File, leak.Line, leak.Column, leak.Message)
+			if merged, ok := leaks[key]; ok {
+				merged.Configurations = append(merged.Configurations, b.String())
+				continue
+			}
+			leaks[key] = leak
+			leak.Configurations = []string{b.String()}
+			result.Leaks = append(result.Leaks, leak)
+		}
+		for _, position := range buildExported {
+			exported[position] = true
+		}
+	}
+
+	if analysed == 0 {
+		return Result{}, excluded
+	}
+	result.Exported = len(exported)
+	return result, nil
+}
+
+//run computes the leaks of the package in dir for build.Default. It also
+//returns the positions of the exported identifiers, to count them across
+//builds.
+func run(gopath string, dir string, root string) (Result, []string, error) {
+	//Get paths
+	packagePath, err := depbleed.GetPackagePath(gopath, dir)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	rootPath, err := depbleed.GetPackagePath(gopath, root)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	if _, err := build.Import(packagePath, "", 0); err != nil {
+		if _, ok := err.(*build.NoGoError); ok {
+			return Result{}, nil, err
+		}
+	}
+	packageInfo, err := depbleed.GetPackageInfo(packagePath)
+	if err != nil {
+		return Result{}, nil, err
+	}
+
+	result := Result{Leaks: []*persistence.Leak{}}
+
+	//Count the exported identifiers the leaks are relative to
+	exported := exportedIdentifiers(packageInfo.Package, packageInfo.Fset)
+	result.Exported = len(exported)
+
+	//Compute leaks
+	for _, leak := range packageInfo.Leaks() {
+		result.Leaks = append(result.Leaks, &persistence.Leak{
+			Message: strings.Replace(leak.Error(), packagePath, strings.TrimPrefix(packagePath, rootPath+"/"), 1),
+			Column:  leak.Position.Column,
+			Line:    leak.Position.Line,
+			File:    strings.Replace(leak.Position.Filename, filepath.Clean(root)+"/", "", 1),
+			Kind:    persistence.LeakKindOf(leak.Error()),
+		})
+	}
+	return result, exported, nil
+}
+
+//Go is the language of GoAnalyzer
+const Go = "GO"
+
+//GoAnalyzer type-checks the Go package at the root of the repositories in
+//the sandbox
+type GoAnalyzer struct {
+	Sandbox *Sandbox
+	//GOPATH must contain the directory the repositories are checked out in
+	GOPATH string
+}
+
+//Language returns Go
+func (g *GoAnalyzer) Language() string {
+	return Go
+}
+
+//Detect tells whether dir has Go files other than tests
+func (g *GoAnalyzer) Detect(dir string) bool {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		name := file.Name()
+		if !file.IsDir() && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
+//Analyse computes the leaks of the package in dir like Run, for the builds
+//of the sandbox
+func (g *GoAnalyzer) Analyse(ctx context.Context, dir string, root string) (Result, error) {
+	return g.Sandbox.Run(ctx, g.GOPATH, dir, root)
+}
+
+//exportedIdentifiers returns the positions of the exported identifiers of
+//the package scope along with the exported fields and methods of its
+//exported types. The exported locals, like a capitalised variable of a
+//function, aren't part of the API.
+func exportedIdentifiers(pkg *types.Package, fset *token.FileSet) []string {
+	var exported []string
+	add := func(obj types.Object) {
+		if obj.Exported() {
+			exported = append(exported, fset.Position(obj.Pos()).String()+" "+obj.Name())
+		}
+	}
+
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		add(obj)
+
+		typeName, ok := obj.(*types.TypeName)
+		if !ok || typeName.IsAlias() {
+			continue
+		}
+		if named, ok := typeName.Type().(*types.Named); ok {
+			for i := 0; i < named.NumMethods(); i++ {
+				add(named.Method(i))
+			}
+		}
+		switch underlying := typeName.Type().Underlying().(type) {
+		case *types.Struct:
+			for i := 0; i < underlying.NumFields(); i++ {
+				add(underlying.Field(i))
+			}
+		case *types.Interface:
+			for i := 0; i < underlying.NumExplicitMethods(); i++ {
+				add(underlying.ExplicitMethod(i))
+			}
+		}
+	}
+	return exported
+}
@@ -0,0 +1,351 @@
+package analyzer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+func TestMain(m *testing.M) {
+	//The test binary doubles as the sandbox
+	if os.Getenv("DEPBLEED_SANDBOX_TEST") == "1" {
+		if err := Serve(os.Stdin, os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// newGOPATH creates a GOPATH with a repository leaking a type of another
+// package and returns the GOPATH and the repositories root
+func newGOPATH(t *testing.T) (string, string) {
+	gopath, err := ioutil.TempDir("", "gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"src/example.com/other/other.go":                 "package other\n\ntype T struct{}\n",
+		"src/example.com/repositories/user/repo/repo.go": "package repo\n\nimport \"example.com/other\"\n\nfunc F() other.T {\n\treturn other.T{}\n}\n",
+		//Only type-checked for the builds of windows and with the extra tag
+		"src/example.com/repositories/user/repo/repo_windows.go": "package repo\n\nimport \"example.com/other\"\n\nfunc G() *other.T {\n\treturn nil\n}\n",
+		"src/example.com/repositories/user/repo/repo_extra.go":   "//go:build extra\n\npackage repo\n\nimport \"example.com/other\"\n\nvar H other.T\n",
+		"src/example.com/repositories/user/windows/windows_x.go": "//go:build windows\n\npackage windows\n",
+		//Exports T, T.Field, T.Method, I, I.Method and F
+		"src/example.com/repositories/user/api/api.go": "package api\n\ntype T struct {\n\tField int\n\tfield int\n}\n\nfunc (T) Method() {}\n\ntype t struct {\n\tField int\n}\n\nfunc (t) Method() {}\n\ntype I interface {\n\tMethod()\n}\n\nfunc F() {\n\tLocal := 1\n\t_ = Local\n}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(gopath, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return gopath, filepath.Join(gopath, "src", "example.com", "repositories")
+}
+
+func newSandbox() *Sandbox {
+	sandbox := NewSandbox(os.Args[0])
+	sandbox.Env = []string{"DEPBLEED_SANDBOX_TEST=1"}
+	return sandbox
+}
+
+func TestSandboxRun(t *testing.T) {
+	gopath, root := newGOPATH(t)
+	defer os.RemoveAll(gopath)
+
+	result, err := newSandbox().Run(context.Background(), gopath, filepath.Join(root, "user", "repo"), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Exported != 1 {
+		t.Errorf("expected 1 exported identifier; got %d", result.Exported)
+	}
+	if len(result.Leaks) != 1 {
+		t.Fatalf("expected 1 leak; got %d", len(result.Leaks))
+	}
+
+	leak := result.Leaks[0]
+	if leak.Kind != persistence.LeakGlobal {
+		t.Errorf("expected a global leak; got %s", leak.Kind)
+	}
+	if leak.File != "user/repo/repo.go" || leak.Line != 5 || leak.Column != 6 {
+		t.Errorf("expected leak at user/repo/repo.go:5:6; got %s:%d:%d", leak.File, leak.Line, leak.Column)
+	}
+	expected := "F: function result 0 is an external type: other.T is a global type from example.com/other"
+	if leak.Message != expected {
+		t.Errorf("expected message %q; got %q", expected, leak.Message)
+	}
+
+	//Only the root is stripped from the packages of the messages, even when
+	//the other packages share it
+	host := filepath.Join(gopath, "src", "example.com")
+	result, err = newSandbox().Run(context.Background(), gopath, filepath.Join(root, "user", "repo"), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leak := result.Leaks[0]; leak.File != "repositories/user/repo/repo.go" || leak.Message != expected {
+		t.Errorf("expected the leak of repositories/user/repo/repo.go with message %q; got %+v", expected, leak)
+	}
+}
+
+func TestSandboxError(t *testing.T) {
+	gopath, root := newGOPATH(t)
+	defer os.RemoveAll(gopath)
+
+	if _, err := newSandbox().Run(context.Background(), gopath, filepath.Join(root, "user", "none"), root); err == nil {
+		t.Errorf("expected an error for a missing package")
+	}
+}
+
+func TestSandboxTimeout(t *testing.T) {
+	gopath, root := newGOPATH(t)
+	defer os.RemoveAll(gopath)
+
+	sandbox := newSandbox()
+	sandbox.Timeout = time.Nanosecond
+
+	_, err := sandbox.Run(context.Background(), gopath, filepath.Join(root, "user", "repo"), root)
+	if err == nil || err.Error() != "analysis took more than 1ns" {
+		t.Errorf("expected a timeout error; got %v", err)
+	}
+
+	//The deadline of the caller isn't the one of the sandbox
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	sandbox.Timeout = time.Hour
+	_, err = sandbox.Run(ctx, gopath, filepath.Join(root, "user", "repo"), root)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline of the caller; got %v", err)
+	}
+}
+
+//fakeAnalyzer detects the directories containing a file named after its
+//language
+type fakeAnalyzer struct {
+	language string
+	leaks    int
+}
+
+func (f *fakeAnalyzer) Language() string {
+	return f.language
+}
+
+func (f *fakeAnalyzer) Detect(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, f.language))
+	return err == nil
+}
+
+func (f *fakeAnalyzer) Analyse(ctx context.Context, dir string, root string) (Result, error) {
+	result := Result{Leaks: []*persistence.Leak{}, Exported: 10}
+	for i := 0; i < f.leaks; i++ {
+		result.Leaks = append(result.Leaks, &persistence.Leak{Kind: persistence.LeakGlobal})
+	}
+	return result, nil
+}
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "B"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "C"), nil, 0644)
+
+	registry := NewRegistry(&fakeAnalyzer{language: "A"}, &fakeAnalyzer{language: "B"}, &fakeAnalyzer{language: "C"})
+
+	if languages := registry.Languages(); len(languages) != 3 || languages[0] != "A" || languages[2] != "C" {
+		t.Errorf("expected the languages in the order of registration; got %v", languages)
+	}
+	if a := registry.Get("C"); a == nil || a.Language() != "C" {
+		t.Errorf("expected the analyzer of C; got %v", a)
+	}
+	if a := registry.Get("D"); a != nil {
+		t.Errorf("expected no analyzer for D; got %v", a)
+	}
+	if a := registry.Detect(dir); a == nil || a.Language() != "B" {
+		t.Errorf("expected the first analyzer detected to be B; got %v", a)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a language twice to panic")
+		}
+	}()
+	registry.Register(&fakeAnalyzer{language: "A"})
+}
+
+func TestGoAnalyzerDetect(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		files    []string
+		expected bool
+	}{
+		{"package", []string{"repo.go", "repo_test.go"}, true},
+		{"tests only", []string{"repo_test.go"}, false},
+		{"subpackage only", []string{"sub/sub.go", "README.md"}, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "repo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			for _, name := range testCase.files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				os.MkdirAll(filepath.Dir(path), 0755)
+				if err := ioutil.WriteFile(path, []byte("package repo\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if detected := (&GoAnalyzer{}).Detect(dir); detected != testCase.expected {
+				t.Errorf("expected detected %v; got %v", testCase.expected, detected)
+			}
+		})
+	}
+}
+
+func TestPipelineAnalyseDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "B"), nil, 0644)
+
+	pipeline := &Pipeline{Analyzers: NewRegistry(&fakeAnalyzer{language: "A"}, &fakeAnalyzer{language: "B", leaks: 2})}
+
+	testCases := []struct {
+		language string
+		analyzed string
+		status   string
+		reason   string
+	}{
+		{"", "B", persistence.StatusDone, ""},
+		{"B", "B", persistence.StatusDone, ""},
+		{"A", "A", persistence.StatusRefused, "no A code found"},
+		{"D", "D", persistence.StatusRefused, "D isn't supported"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.language, func(t *testing.T) {
+			language, analysis, err := pipeline.AnalyseDir(context.Background(), dir, dir, testCase.language, "abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if language != testCase.analyzed || analysis.Status != testCase.status || analysis.Reason != testCase.reason {
+				t.Errorf("expected %s %s %q; got %s %s %q", testCase.analyzed, testCase.status, testCase.reason, language, analysis.Status, analysis.Reason)
+			}
+			if analysis.Status == persistence.StatusDone && (len(analysis.Leaks) != 2 || analysis.Exported != 10 || analysis.Hash != "abc") {
+				t.Errorf("expected the result of the analyzer; got %+v", analysis)
+			}
+		})
+	}
+
+	os.Remove(filepath.Join(dir, "B"))
+	if _, analysis, _ := pipeline.AnalyseDir(context.Background(), dir, dir, "", "abc"); analysis.Status != persistence.StatusRefused {
+		t.Errorf("expected a repository without a supported language to be refused; got %s", analysis.Status)
+	}
+}
+
+func TestSandboxRunBuilds(t *testing.T) {
+	gopath, root := newGOPATH(t)
+	defer os.RemoveAll(gopath)
+
+	sandbox := newSandbox()
+	sandbox.Builds = []Build{{GOOS: "linux", GOARCH: "amd64"}, {GOOS: "windows", GOARCH: "amd64"}, {GOOS: "linux", GOARCH: "arm64", Tags: []string{"extra"}}}
+
+	result, err := sandbox.Run(context.Background(), gopath, filepath.Join(root, "user", "repo"), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Exported != 3 {
+		t.Errorf("expected the 3 identifiers exported by any build; got %d", result.Exported)
+	}
+
+	expected := map[string]string{
+		"user/repo/repo.go":         "linux/amd64,windows/amd64,linux/arm64 extra",
+		"user/repo/repo_windows.go": "windows/amd64",
+		"user/repo/repo_extra.go":   "linux/arm64 extra",
+	}
+	if len(result.Leaks) != len(expected) {
+		t.Fatalf("expected %d leaks; got %d", len(expected), len(result.Leaks))
+	}
+	for _, leak := range result.Leaks {
+		if configurations := strings.Join(leak.Configurations, ","); configurations != expected[leak.File] {
+			t.Errorf("expected the leak of %s in %s; got %s", leak.File, expected[leak.File], configurations)
+		}
+	}
+
+	//The builds excluding all the files of the package are skipped
+	result, err = sandbox.Run(context.Background(), gopath, filepath.Join(root, "user", "windows"), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Leaks) != 0 {
+		t.Errorf("expected no leaks; got %d", len(result.Leaks))
+	}
+
+	sandbox.Builds = sandbox.Builds[:1]
+	if _, err := sandbox.Run(context.Background(), gopath, filepath.Join(root, "user", "windows"), root); err == nil {
+		t.Errorf("expected an error when no build has files")
+	}
+}
+
+func TestSandboxRunExported(t *testing.T) {
+	gopath, root := newGOPATH(t)
+	defer os.RemoveAll(gopath)
+
+	result, err := newSandbox().Run(context.Background(), gopath, filepath.Join(root, "user", "api"), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Exported != 6 {
+		t.Errorf("expected the 6 identifiers of the API; got %d", result.Exported)
+	}
+}
+
+func TestParseBuild(t *testing.T) {
+
+	testCases := []struct {
+		s        string
+		expected Build
+		valid    bool
+	}{
+		{"linux/amd64", Build{GOOS: "linux", GOARCH: "amd64"}, true},
+		{" windows/386  appengine purego ", Build{GOOS: "windows", GOARCH: "386", Tags: []string{"appengine", "purego"}}, true},
+		{"", Build{}, false},
+		{"linux", Build{}, false},
+		{"linux/amd64/v2", Build{}, false},
+		{"linux/amd64 !cgo", Build{}, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.s, func(t *testing.T) {
+			b, err := ParseBuild(testCase.s)
+			if (err == nil) != testCase.valid {
+				t.Fatalf("expected valid %v; got %v", testCase.valid, err)
+			}
+			if !reflect.DeepEqual(b, testCase.expected) {
+				t.Errorf("expected %+v; got %+v", testCase.expected, b)
+			}
+			if testCase.valid && b.String() != strings.Join(strings.Fields(testCase.s), " ") {
+				t.Errorf("expected %q; got %q", testCase.s, b.String())
+			}
+		})
+	}
+}
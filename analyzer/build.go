@@ -0,0 +1,48 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//Build is a build configuration the packages are type-checked for. The
+//files of the other configurations, like the _windows.go ones on linux, are
+//ignored by the type-checking.
+type Build struct {
+	GOOS   string   `json:"goos"`
+	GOARCH string   `json:"goarch"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+//buildName matches the GOOS, GOARCH and tags
+var buildName = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+//ParseBuild parses a build configuration written like String:
+//GOOS/GOARCH followed by the build tags, separated by spaces
+func ParseBuild(s string) (Build, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Build{}, fmt.Errorf("expected GOOS/GOARCH followed by build tags; got %q", s)
+	}
+
+	platform := strings.Split(fields[0], "/")
+	if len(platform) != 2 || !buildName.MatchString(platform[0]) || !buildName.MatchString(platform[1]) {
+		return Build{}, fmt.Errorf("expected GOOS/GOARCH followed by build tags; got %q", s)
+	}
+	for _, tag := range fields[1:] {
+		if !buildName.MatchString(tag) {
+			return Build{}, fmt.Errorf("invalid build tag %q in %q", tag, s)
+		}
+	}
+
+	b := Build{GOOS: platform[0], GOARCH: platform[1]}
+	if len(fields) > 1 {
+		b.Tags = fields[1:]
+	}
+	return b, nil
+}
+
+func (b Build) String() string {
+	return strings.Join(append([]string{b.GOOS + "/" + b.GOARCH}, b.Tags...), " ")
+}
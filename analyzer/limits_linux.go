@@ -0,0 +1,27 @@
+//go:build linux
+// +build linux
+
+package analyzer
+
+import "syscall"
+
+//setLimits applies the limits to the current process
+func setLimits(limits Limits) error {
+	if limits.CPUTime > 0 {
+		seconds := uint64(limits.CPUTime.Seconds())
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: seconds, Max: seconds}); err != nil {
+			return err
+		}
+	}
+	if limits.Memory > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: limits.Memory, Max: limits.Memory}); err != nil {
+			return err
+		}
+	}
+	if limits.Files > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: limits.Files, Max: limits.Files}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package analyzer
+
+//setLimits is a no-op: the rlimits are only applied on linux, the wall-clock
+//timeout of the sandbox still applies
+func setLimits(limits Limits) error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package analyzer
+
+import (
+	"context"
+	"time"
+
+	"github.com/depbleed/backend/git"
+	"github.com/depbleed/backend/persistence"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	//PhaseDuration is the duration of the phases of the analyses: clone,
+	//typecheck and persist
+	PhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "depbleed_analysis_phase_duration_seconds",
+		Help:    "Duration of the analysis phases.",
+		Buckets: []float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"phase"})
+	//leaksFound counts the leaks found by the analyses
+	leaksFound = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "depbleed_leaks_found_total",
+		Help: "Leaks found by the analyses.",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(PhaseDuration, leaksFound)
+}
+
+//Pipeline checks out the repositories and analyzes them with the analyzer
+//of their language
+type Pipeline struct {
+	Cache     *git.Cache
+	Analyzers *Registry
+}
+
+//Analyse checks out commit of repo (user/repo) and computes its leaks with
+//the analyzer of language, or of the language detected in the checkout when
+//it is empty. It returns the language with the analysis.
+//Refused and failed analyses are returned with the corresponding status so
+//they can be stored; an error is only returned when the analysis should be
+//retried later.
+func (p *Pipeline) Analyse(ctx context.Context, repo string, language string, commit string) (string, *persistence.Analysis, error) {
+	start := time.Now()
+	path, release, err := p.Cache.Checkout(ctx, repo, commit)
+	PhaseDuration.WithLabelValues("clone").Observe(time.Since(start).Seconds())
+	if refused, ok := err.(*git.RefusedError); ok {
+		//Store the refusal so the repo isn't cloned again for this commit
+		return language, refuse(commit, refused.Reason), nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
+	return p.AnalyseDir(ctx, path, p.Cache.Dir(), language, commit)
+}
+
+//AnalyseDir computes the leaks of the repository checked out in dir like
+//Analyse. root is stripped from the file names like in Run.
+func (p *Pipeline) AnalyseDir(ctx context.Context, dir string, root string, language string, commit string) (string, *persistence.Analysis, error) {
+	var a Analyzer
+	switch {
+	case language == "":
+		if a = p.Analyzers.Detect(dir); a == nil {
+			return language, refuse(commit, "no supported language detected"), nil
+		}
+	case p.Analyzers.Get(language) == nil:
+		return language, refuse(commit, language+" isn't supported"), nil
+	default:
+		if a = p.Analyzers.Get(language); !a.Detect(dir) {
+			return language, refuse(commit, "no "+language+" code found"), nil
+		}
+	}
+
+	analysis := newAnalysis(commit)
+
+	start := time.Now()
+	result, err := a.Analyse(ctx, dir, root)
+	PhaseDuration.WithLabelValues("typecheck").Observe(time.Since(start).Seconds())
+	if ctx.Err() != nil {
+		return "", nil, ctx.Err()
+	} else if err != nil {
+		analysis.Status = persistence.StatusFailed
+		analysis.Reason = err.Error()
+		return a.Language(), analysis, nil
+	}
+
+	//Append all the leaks
+	analysis.Leaks = append(analysis.Leaks, result.Leaks...)
+	for _, leak := range result.Leaks {
+		leaksFound.WithLabelValues(leak.Kind).Inc()
+	}
+	analysis.Exported = result.Exported
+	analysis.Score = Score(analysis.Leaks, analysis.Exported)
+	analysis.Grade = Grade(analysis.Score)
+	return a.Language(), analysis, nil
+}
+
+//refuse returns the refused analysis of commit
+func refuse(commit string, reason string) *persistence.Analysis {
+	analysis := newAnalysis(commit)
+	analysis.Status = persistence.StatusRefused
+	analysis.Reason = reason
+	return analysis
+}
+
+//newAnalysis returns an empty analysis of commit
+func newAnalysis(commit string) *persistence.Analysis {
+	return &persistence.Analysis{
+		Hash:   commit,
+		Leaks:  []*persistence.Leak{},
+		Time:   time.Now().Unix(),
+		Status: persistence.StatusDone,
+	}
+}
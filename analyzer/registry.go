@@ -0,0 +1,63 @@
+package analyzer
+
+import "context"
+
+//Analyzer computes the leaks of the repositories of a language
+type Analyzer interface {
+	//Language is the name of the language in the stored repositories, like
+	//GO
+	Language() string
+	//Detect tells whether the repository checked out in dir is written in
+	//the language
+	Detect(dir string) bool
+	//Analyse computes the leaks of the repository checked out in dir. root
+	//is the directory the repositories are checked out in.
+	Analyse(ctx context.Context, dir string, root string) (Result, error)
+}
+
+//Registry holds the analyzers by language
+type Registry struct {
+	analyzers map[string]Analyzer
+	//languages are in the order of the detection
+	languages []string
+}
+
+//NewRegistry returns a registry of analyzers
+func NewRegistry(analyzers ...Analyzer) *Registry {
+	r := &Registry{analyzers: map[string]Analyzer{}}
+	r.Register(analyzers...)
+	return r
+}
+
+//Register adds analyzers; the ones registered first are detected first. It
+//panics when a language already has an analyzer.
+func (r *Registry) Register(analyzers ...Analyzer) {
+	for _, a := range analyzers {
+		if _, ok := r.analyzers[a.Language()]; ok {
+			panic("analyzer: " + a.Language() + " is already registered")
+		}
+		r.analyzers[a.Language()] = a
+		r.languages = append(r.languages, a.Language())
+	}
+}
+
+//Get returns the analyzer of language, or nil
+func (r *Registry) Get(language string) Analyzer {
+	return r.analyzers[language]
+}
+
+//Detect returns the first analyzer whose language the repository checked
+//out in dir is written in, or nil
+func (r *Registry) Detect(dir string) Analyzer {
+	for _, language := range r.languages {
+		if a := r.analyzers[language]; a.Detect(dir) {
+			return a
+		}
+	}
+	return nil
+}
+
+//Languages returns the languages of the analyzers
+func (r *Registry) Languages() []string {
+	return append([]string{}, r.languages...)
+}
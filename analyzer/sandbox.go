@@ -0,0 +1,150 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+//Request is sent by the parent process on the sandbox stdin
+type Request struct {
+	GOPATH string  `json:"gopath"`
+	Dir    string  `json:"dir"`
+	Root   string  `json:"root"`
+	Builds []Build `json:"builds,omitempty"`
+	Limits Limits  `json:"limits"`
+}
+
+//Response is written by the sandbox on its stdout
+type Response struct {
+	Result
+	Error string `json:"error,omitempty"`
+}
+
+//Limits are the resource limits the sandbox applies to itself before
+//loading any code. Zero values disable the corresponding limit.
+type Limits struct {
+	CPUTime time.Duration `json:"cpu_time"`
+	Memory  uint64        `json:"memory"`
+	Files   uint64        `json:"files"`
+}
+
+//Sandbox runs the analyses in a subprocess with cgo disabled, a scrubbed
+//environment and resource limits
+type Sandbox struct {
+	//Command starts a process calling Serve
+	Command []string
+	//Env is added to the scrubbed environment of the subprocess
+	Env []string
+	//Timeout is the wall-clock time an analysis may take
+	Timeout time.Duration
+	Limits  Limits
+	//Builds are the build configurations the packages are type-checked
+	//for; the one of the subprocess if empty
+	Builds []Build
+}
+
+//NewSandbox returns a sandbox running command with the default limits
+func NewSandbox(command ...string) *Sandbox {
+	return &Sandbox{
+		Command: command,
+		Timeout: 2 * time.Minute,
+		Limits: Limits{
+			CPUTime: time.Minute,
+			Memory:  2 << 30,
+			Files:   256,
+		},
+	}
+}
+
+//Run computes the leaks of the package in dir for the builds like the Run
+//function, in a subprocess
+func (s *Sandbox) Run(ctx context.Context, gopath string, dir string, root string) (Result, error) {
+	parent := ctx
+	if s.Timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	request, err := json.Marshal(Request{
+		GOPATH: gopath,
+		Dir:    dir,
+		Root:   root,
+		Builds: s.Builds,
+		Limits: s.Limits,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Dir = dir
+	cmd.Env = append([]string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"GOPATH=" + gopath,
+		"GOROOT=" + build.Default.GOROOT,
+		"CGO_ENABLED=0",
+		"GO111MODULE=off",
+	}, s.Env...)
+
+	if err := cmd.Run(); err != nil {
+		if parent.Err() != nil {
+			//The caller gave up, not the analysis
+			return Result{}, parent.Err()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return Result{}, fmt.Errorf("analysis took more than %s", s.Timeout)
+		}
+		return Result{}, fmt.Errorf("sandbox failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var response Response
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return Result{}, fmt.Errorf("invalid sandbox response: %s", err)
+	}
+	if response.Error != "" {
+		return Result{}, errors.New(response.Error)
+	}
+	return response.Result, nil
+}
+
+//Serve reads a Request from in, applies its limits, runs the analysis and
+//writes the Response to out. It is meant to be the only thing the sandbox
+//process does.
+func Serve(in io.Reader, out io.Writer) error {
+	var request Request
+	if err := json.NewDecoder(in).Decode(&request); err != nil {
+		return err
+	}
+
+	if err := setLimits(request.Limits); err != nil {
+		return err
+	}
+
+	//Never run the C toolchain on the analyzed code
+	build.Default.CgoEnabled = false
+	build.Default.GOPATH = request.GOPATH
+
+	var response Response
+	result, err := Run(request.GOPATH, request.Dir, request.Root, request.Builds)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Result = result
+	}
+	return json.NewEncoder(out).Encode(response)
+}
@@ -0,0 +1,58 @@
+package analyzer
+
+import (
+	"math"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//kindWeights are the weights of the leak kinds in the score: a vendored type
+//can't even be named by the users of the package
+var kindWeights = map[string]float64{
+	persistence.LeakVendored: 2,
+	persistence.LeakGlobal:   1,
+}
+
+//grades are the minimum scores of the letter grades
+var grades = []struct {
+	Score float64
+	Grade string
+}{
+	{Score: 99, Grade: "A+"},
+	{Score: 95, Grade: "A"},
+	{Score: 90, Grade: "B"},
+	{Score: 80, Grade: "C"},
+	{Score: 70, Grade: "D"},
+	{Score: 60, Grade: "E"},
+}
+
+//Score returns the score out of 100 of a package: the share of its exported
+//identifiers that don't leak, weighted by the kind of the leaks
+func Score(leaks []*persistence.Leak, exported int) float64 {
+	if exported == 0 {
+		return 100
+	}
+
+	weighted := 0.0
+	for _, leak := range leaks {
+		kind := leak.Kind
+		if kind == "" {
+			kind = persistence.LeakKindOf(leak.Message)
+		}
+		weighted += kindWeights[kind]
+	}
+
+	score := 100 * (1 - weighted/float64(exported))
+	//Keep two decimals
+	return math.Max(0, math.Floor(score*100+0.5)/100)
+}
+
+//Grade returns the letter grade of a score, from A+ to F
+func Grade(score float64) string {
+	for _, grade := range grades {
+		if score >= grade.Score {
+			return grade.Grade
+		}
+	}
+	return "F"
+}
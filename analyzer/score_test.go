@@ -0,0 +1,41 @@
+package analyzer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+func TestScore(t *testing.T) {
+
+	global := &persistence.Leak{Kind: persistence.LeakGlobal}
+	vendored := &persistence.Leak{Message: "F: x.T is a vendorized type from a/vendor/x"}
+
+	testCases := []struct {
+		Leaks    []*persistence.Leak
+		Exported int
+		Score    float64
+		Grade    string
+	}{
+		{Leaks: nil, Exported: 0, Score: 100, Grade: "A+"},
+		{Leaks: nil, Exported: 10, Score: 100, Grade: "A+"},
+		{Leaks: []*persistence.Leak{global}, Exported: 40, Score: 97.5, Grade: "A"},
+		{Leaks: []*persistence.Leak{vendored}, Exported: 40, Score: 95, Grade: "A"},
+		{Leaks: []*persistence.Leak{global, vendored}, Exported: 3, Score: 0, Grade: "F"},
+		{Leaks: []*persistence.Leak{global}, Exported: 3, Score: 66.67, Grade: "E"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("%d/%d", len(testCase.Leaks), testCase.Exported), func(t *testing.T) {
+			score := Score(testCase.Leaks, testCase.Exported)
+
+			if score != testCase.Score {
+				t.Errorf("expected score %v; got %v", testCase.Score, score)
+			}
+			if grade := Grade(score); grade != testCase.Grade {
+				t.Errorf("expected grade %s; got %s", testCase.Grade, grade)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+//Package config loads the configuration of the API and the workers from
+//the flags, the environment and an optional JSON file
+package config
+
+import (
+	"fmt"
+	"go/build"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/depbleed/backend/analyzer"
+	"github.com/depbleed/backend/git"
+	"github.com/depbleed/backend/logger"
+	"github.com/depbleed/backend/persistence"
+)
+
+//Config is the configuration of the API and the workers
+type Config struct {
+	//Port is the port the API listens on
+	Port int `json:"port"`
+	//Workspace is where the repositories are checked out. It must be in
+	//GOPATH for the analyses to type-check.
+	Workspace string `json:"workspace"`
+	//Workers is the number of jobs a worker process runs at once
+	Workers int `json:"workers"`
+	//CacheSize is the disk space the repository mirrors may use
+	CacheSize Size `json:"cache_size"`
+	//ShutdownTimeout is how long the requests in flight have to complete
+	//on shutdown
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	//DrainTimeout is how long the running jobs have to complete on
+	//shutdown before they are queued again
+	DrainTimeout Duration `json:"drain_timeout"`
+	//StoreTimeout bounds each call a request makes to the store
+	StoreTimeout Duration `json:"store_timeout"`
+	//GithubToken authenticates the github API calls for a higher rate limit
+	GithubToken string `json:"github_token"`
+	//GithubTimeout bounds each call a request makes to the github API
+	GithubTimeout Duration `json:"github_timeout"`
+	//AllowedHosts are the hosts the API answers for; any host if empty
+	AllowedHosts List `json:"allowed_hosts"`
+	//CORSOrigins are the origins allowed to call the API from a browser
+	CORSOrigins List `json:"cors_origins"`
+	//MetricsPort is the port the workers expose their metrics on; 0
+	//disables it
+	MetricsPort int    `json:"metrics_port"`
+	LogLevel    string `json:"log_level"`
+	LogFormat   string `json:"log_format"`
+
+	//Storage is where the repositories and the jobs are kept: mongo,
+	//postgres, bolt or memory. The bolt and memory stores can't be shared between
+	//processes; the API runs the workers itself with them.
+	Storage   string                     `json:"storage"`
+	Mongo     persistence.MongoConfig    `json:"mongo"`
+	Postgres  persistence.PostgresConfig `json:"postgres"`
+	Bolt      persistence.BoltConfig     `json:"bolt"`
+	Limits    Limits                     `json:"limits"`
+	Sandbox   Sandbox                    `json:"sandbox"`
+	Retention Retention                  `json:"retention"`
+}
+
+const (
+	//StorageMongo keeps the data in MongoDB
+	StorageMongo = "mongo"
+	//StoragePostgres keeps the data in PostgreSQL
+	StoragePostgres = "postgres"
+	//StorageBolt keeps the data in a local file
+	StorageBolt = "bolt"
+	//StorageMemory keeps the data in memory until the process exits
+	StorageMemory = "memory"
+)
+
+//Limits bounds the resources an analyzed repository may use, like
+//git.Limits
+type Limits struct {
+	CloneTimeout Duration `json:"clone_timeout"`
+	MaxRepoSize  Size     `json:"max_repo_size"`
+	MaxGoFiles   int      `json:"max_go_files"`
+	Submodules   string   `json:"submodules"`
+	LFS          string   `json:"lfs"`
+}
+
+//Sandbox bounds the type-checking subprocess, like analyzer.Limits
+type Sandbox struct {
+	Timeout Duration `json:"timeout"`
+	CPUTime Duration `json:"cpu_time"`
+	Memory  Size     `json:"memory"`
+	Files   int      `json:"files"`
+	//Builds are the build configurations type-checked, like analyzer.Build;
+	//the one of the host if empty
+	Builds List `json:"builds"`
+}
+
+//Retention tells which analyses and repositories are pruned, like
+//persistence.Retention, and how often the workers prune them
+type Retention struct {
+	Analyses    int      `json:"analyses"`
+	Unrequested Duration `json:"unrequested"`
+	//Interval is how often the workers prune; never if 0
+	Interval Duration `json:"interval"`
+}
+
+//redacted replaces the secrets in Redacted
+const redacted = "REDACTED"
+
+//Default returns the configuration used for the values that aren't set
+func Default() *Config {
+	sandbox := analyzer.NewSandbox()
+
+	return &Config{
+		Port:            80,
+		Workspace:       "repositories",
+		Workers:         1,
+		CacheSize:       2 << 30,
+		ShutdownTimeout: Duration(20 * time.Second),
+		DrainTimeout:    Duration(20 * time.Second),
+		StoreTimeout:    Duration(5 * time.Second),
+		GithubTimeout:   Duration(10 * time.Second),
+		LogLevel:        logger.LevelInfo.String(),
+		LogFormat:       string(logger.FormatLogfmt),
+		Storage:         StorageMongo,
+		Bolt:            persistence.BoltConfig{Path: "depbleed.db"},
+		Limits: Limits{
+			CloneTimeout: Duration(git.DefaultLimits.CloneTimeout),
+			MaxRepoSize:  Size(git.DefaultLimits.MaxRepoSize),
+			MaxGoFiles:   git.DefaultLimits.MaxGoFiles,
+			Submodules:   string(git.DefaultLimits.Submodules),
+			LFS:          string(git.DefaultLimits.LFS),
+		},
+		Sandbox: Sandbox{
+			Timeout: Duration(sandbox.Timeout),
+			CPUTime: Duration(sandbox.Limits.CPUTime),
+			Memory:  Size(sandbox.Limits.Memory),
+			Files:   int(sandbox.Limits.Files),
+		},
+	}
+}
+
+//ValidationError lists the invalid values of a configuration
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
+
+//Validate checks the values of the configuration
+func (c *Config) Validate() error {
+	var problems ValidationError
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		problem("port must be between 1 and 65535; got %d", c.Port)
+	}
+	if c.MetricsPort < 0 || c.MetricsPort > 65535 {
+		problem("metrics_port must be between 0 and 65535; got %d", c.MetricsPort)
+	}
+	if c.Workspace == "" {
+		problem("workspace is required")
+	}
+	if c.Workers < 1 {
+		problem("workers must be at least 1; got %d", c.Workers)
+	}
+	if c.CacheSize < 0 {
+		problem("cache_size can't be negative; got %s", c.CacheSize)
+	}
+	if c.ShutdownTimeout <= 0 {
+		problem("shutdown_timeout must be positive; got %s", c.ShutdownTimeout)
+	}
+	if c.DrainTimeout <= 0 {
+		problem("drain_timeout must be positive; got %s", c.DrainTimeout)
+	}
+	if c.StoreTimeout <= 0 {
+		problem("store_timeout must be positive; got %s", c.StoreTimeout)
+	}
+	if c.GithubTimeout <= 0 {
+		problem("github_timeout must be positive; got %s", c.GithubTimeout)
+	}
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		problem("log_level must be debug, info, warn or error; got %q", c.LogLevel)
+	}
+	if _, err := logger.ParseFormat(c.LogFormat); err != nil {
+		problem("log_format must be logfmt or json; got %q", c.LogFormat)
+	}
+
+	for _, host := range c.AllowedHosts {
+		if strings.Contains(host, "/") {
+			problem("allowed_hosts must be host names like depbleed.io, without scheme nor path; got %q", host)
+		}
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || strings.TrimSuffix(parsed.Path, "/") != "" {
+			problem("cors_origins must be * or origins like https://depbleed.io; got %q", origin)
+		}
+	}
+
+	switch c.Storage {
+	case StorageMongo:
+		if c.Mongo.URL == "" {
+			problem("mongo.url is required")
+		} else if err := c.Mongo.Validate(); err != nil {
+			problem("mongo.url: %v", err)
+		}
+	case StoragePostgres:
+		if c.Postgres.URL == "" {
+			problem("postgres.url is required")
+		} else if err := c.Postgres.Validate(); err != nil {
+			problem("postgres.url: %v", err)
+		}
+	case StorageBolt:
+		if c.Bolt.Path == "" {
+			problem("bolt.path is required")
+		}
+	case StorageMemory:
+	default:
+		problem("storage must be mongo, postgres, bolt or memory; got %q", c.Storage)
+	}
+
+	if c.Limits.CloneTimeout < 0 || c.Limits.MaxRepoSize < 0 || c.Limits.MaxGoFiles < 0 {
+		problem("limits can't be negative")
+	}
+	for name, policy := range map[string]string{"submodules": c.Limits.Submodules, "lfs": c.Limits.LFS} {
+		if git.Policy(policy) != git.Ignore && git.Policy(policy) != git.Refuse {
+			problem("limits.%s must be ignore or refuse; got %q", name, policy)
+		}
+	}
+	if c.Sandbox.Timeout < 0 || c.Sandbox.CPUTime < 0 || c.Sandbox.Memory < 0 || c.Sandbox.Files < 0 {
+		problem("sandbox limits can't be negative")
+	}
+	for _, b := range c.Sandbox.Builds {
+		if _, err := analyzer.ParseBuild(b); err != nil {
+			problem("sandbox.builds: %v", err)
+		}
+	}
+	if c.Retention.Analyses < 0 || c.Retention.Unrequested < 0 || c.Retention.Interval < 0 {
+		problem("retention can't be negative")
+	}
+	if c.Retention.Interval > 0 && c.Retention.Analyses == 0 && c.Retention.Unrequested == 0 {
+		problem("retention.interval requires retention.analyses or retention.unrequested")
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+//Redacted returns a copy of the configuration without the secrets, for
+//printing
+func (c *Config) Redacted() *Config {
+	copy := *c
+	if copy.GithubToken != "" {
+		copy.GithubToken = redacted
+	}
+	copy.Mongo = copy.Mongo.Redacted()
+	copy.Postgres = copy.Postgres.Redacted()
+	return &copy
+}
+
+//Logger returns the logger writing to stdout at the configured level and
+//format. The configuration must be valid.
+func (c *Config) Logger() *logger.Logger {
+	level, _ := logger.ParseLevel(c.LogLevel)
+	format, _ := logger.ParseFormat(c.LogFormat)
+	return logger.New(os.Stdout, level, format)
+}
+
+//GitLimits returns the limits of the repositories
+func (c *Config) GitLimits() git.Limits {
+	return git.Limits{
+		CloneTimeout: time.Duration(c.Limits.CloneTimeout),
+		MaxRepoSize:  int64(c.Limits.MaxRepoSize),
+		MaxGoFiles:   c.Limits.MaxGoFiles,
+		Submodules:   git.Policy(c.Limits.Submodules),
+		LFS:          git.Policy(c.Limits.LFS),
+	}
+}
+
+//Shared tells whether the store can be shared between the API and the
+//worker processes
+func (c *Config) Shared() bool {
+	return c.Storage == StorageMongo || c.Storage == StoragePostgres
+}
+
+//OpenStore opens the configured store. The configuration must be valid.
+func (c *Config) OpenStore() (persistence.Store, error) {
+	switch c.Storage {
+	case StoragePostgres:
+		return persistence.NewPostgres(c.Postgres)
+	case StorageBolt:
+		return persistence.NewBolt(c.Bolt)
+	case StorageMemory:
+		return persistence.NewMemory(), nil
+	default:
+		return persistence.NewMongo(c.Mongo)
+	}
+}
+
+//RetentionPolicy returns the retention policy of the analyses and the
+//repositories
+func (c *Config) RetentionPolicy() persistence.Retention {
+	return persistence.Retention{
+		Analyses:    c.Retention.Analyses,
+		Unrequested: time.Duration(c.Retention.Unrequested),
+	}
+}
+
+//NewPipeline returns the analysis pipeline checking out the repositories in
+//the workspace and analyzing them by running command
+func (c *Config) NewPipeline(command ...string) (*analyzer.Pipeline, error) {
+	cache, err := git.NewCache(c.Workspace, int64(c.CacheSize), c.GitLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	return &analyzer.Pipeline{
+		Cache:     cache,
+		Analyzers: c.NewAnalyzers(command...),
+	}, nil
+}
+
+//NewAnalyzers returns the analyzers of the supported languages, running
+//command as their sandbox
+func (c *Config) NewAnalyzers(command ...string) *analyzer.Registry {
+	return analyzer.NewRegistry(
+		&analyzer.GoAnalyzer{Sandbox: c.NewSandbox(command...), GOPATH: build.Default.GOPATH},
+	)
+}
+
+//NewSandbox returns the sandbox running command with the configured limits
+func (c *Config) NewSandbox(command ...string) *analyzer.Sandbox {
+	sandbox := analyzer.NewSandbox(command...)
+	sandbox.Timeout = time.Duration(c.Sandbox.Timeout)
+	sandbox.Limits = analyzer.Limits{
+		CPUTime: time.Duration(c.Sandbox.CPUTime),
+		Memory:  uint64(c.Sandbox.Memory),
+		Files:   uint64(c.Sandbox.Files),
+	}
+	for _, b := range c.Sandbox.Builds {
+		//Validated
+		parsed, _ := analyzer.ParseBuild(b)
+		sandbox.Builds = append(sandbox.Builds, parsed)
+	}
+	return sandbox
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+//field is a value that can be set from the environment and the flags
+type field struct {
+	flag  string
+	env   string
+	usage string
+	value flag.Value
+}
+
+//fields returns the values of c that can be set from the environment and
+//the flags
+func (c *Config) fields() []field {
+	return []field{
+		{"port", "PORT", "port the API listens on", (*intValue)(&c.Port)},
+		{"workspace", "DEPBLEED_WORKSPACE", "directory the repositories are checked out in", (*stringValue)(&c.Workspace)},
+		{"workers", "DEPBLEED_WORKERS", "jobs a worker process runs at once", (*intValue)(&c.Workers)},
+		{"cache-size", "DEPBLEED_CACHE_SIZE", "disk space the repository mirrors may use", &c.CacheSize},
+		{"shutdown-timeout", "DEPBLEED_SHUTDOWN_TIMEOUT", "time the requests in flight have to complete on shutdown", &c.ShutdownTimeout},
+		{"drain-timeout", "DEPBLEED_DRAIN_TIMEOUT", "time the running jobs have to complete on shutdown", &c.DrainTimeout},
+		{"store-timeout", "DEPBLEED_STORE_TIMEOUT", "time each store call of a request has", &c.StoreTimeout},
+		{"github-token", "GITHUB_TOKEN", "token authenticating the github API calls", (*stringValue)(&c.GithubToken)},
+		{"github-timeout", "DEPBLEED_GITHUB_TIMEOUT", "time each github API call of a request has", &c.GithubTimeout},
+		{"allowed-hosts", "DEPBLEED_ALLOWED_HOSTS", "comma separated hosts the API answers for", &c.AllowedHosts},
+		{"cors-origins", "DEPBLEED_CORS_ORIGINS", "comma separated origins allowed to call the API from a browser", &c.CORSOrigins},
+		{"metrics-port", "METRICS_PORT", "port the workers expose their metrics on", (*intValue)(&c.MetricsPort)},
+		{"log-level", "LOG_LEVEL", "debug, info, warn or error", (*stringValue)(&c.LogLevel)},
+		{"log-format", "LOG_FORMAT", "logfmt or json", (*stringValue)(&c.LogFormat)},
+		{"storage", "DEPBLEED_STORAGE", "mongo, postgres, bolt or memory", (*stringValue)(&c.Storage)},
+		{"mongo-url", "MONGOD_URL", "address of MongoDB", (*stringValue)(&c.Mongo.URL)},
+		{"mongo-db", "MONGOD_DB", "MongoDB database", (*stringValue)(&c.Mongo.DB)},
+		{"mongo-user", "MONGOD_USER", "MongoDB user", (*stringValue)(&c.Mongo.User)},
+		{"mongo-password", "MONGOD_PW", "MongoDB password", (*stringValue)(&c.Mongo.Password)},
+		{"postgres-url", "DATABASE_URL", "PostgreSQL connection string", (*stringValue)(&c.Postgres.URL)},
+		{"bolt-path", "DEPBLEED_BOLT_PATH", "file of the bolt store", (*stringValue)(&c.Bolt.Path)},
+		{"clone-timeout", "DEPBLEED_CLONE_TIMEOUT", "time a repository has to be cloned or fetched", &c.Limits.CloneTimeout},
+		{"max-repo-size", "DEPBLEED_MAX_REPO_SIZE", "size above which the repositories are refused", &c.Limits.MaxRepoSize},
+		{"max-go-files", "DEPBLEED_MAX_GO_FILES", "number of Go files above which the repositories are refused", (*intValue)(&c.Limits.MaxGoFiles)},
+		{"submodules", "DEPBLEED_SUBMODULES", "ignore or refuse the repositories with submodules", (*stringValue)(&c.Limits.Submodules)},
+		{"lfs", "DEPBLEED_LFS", "ignore or refuse the repositories using git LFS", (*stringValue)(&c.Limits.LFS)},
+		{"sandbox-timeout", "DEPBLEED_SANDBOX_TIMEOUT", "time an analysis has to type-check", &c.Sandbox.Timeout},
+		{"sandbox-cpu-time", "DEPBLEED_SANDBOX_CPU_TIME", "CPU time an analysis may use", &c.Sandbox.CPUTime},
+		{"sandbox-memory", "DEPBLEED_SANDBOX_MEMORY", "memory an analysis may use", &c.Sandbox.Memory},
+		{"sandbox-files", "DEPBLEED_SANDBOX_FILES", "files an analysis may open at once", (*intValue)(&c.Sandbox.Files)},
+		{"sandbox-builds", "DEPBLEED_SANDBOX_BUILDS", "comma separated GOOS/GOARCH, each followed by its build tags, to type-check for; the host one if empty", &c.Sandbox.Builds},
+		{"retention-analyses", "DEPBLEED_RETENTION_ANALYSES", "latest analyses kept per repository besides one a month; all if 0", (*intValue)(&c.Retention.Analyses)},
+		{"retention-unrequested", "DEPBLEED_RETENTION_UNREQUESTED", "time the repositories nobody requests are kept; forever if 0", &c.Retention.Unrequested},
+		{"prune-interval", "DEPBLEED_PRUNE_INTERVAL", "how often the workers prune; never if 0", &c.Retention.Interval},
+	}
+}
+
+//Load returns the configuration of the command name. The values are taken
+//from the flags in args, then the environment, then the JSON file given
+//with -config or $DEPBLEED_CONFIG, then the defaults. It isn't validated.
+func Load(name string, args []string, output io.Writer) (*Config, error) {
+	return load(name, args, output, os.LookupEnv)
+}
+
+//LoadFlags is Load for a command with flags of its own, defined in flags
+//and parsed along with the ones of the configuration
+func LoadFlags(flags *flag.FlagSet, args []string) (*Config, error) {
+	return loadFlags(flags, args, os.LookupEnv)
+}
+
+func load(name string, args []string, output io.Writer, lookupEnv func(string) (string, bool)) (*Config, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(output)
+	return loadFlags(flags, args, lookupEnv)
+}
+
+func loadFlags(flags *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Default()
+	fields := c.fields()
+
+	path := flags.String("config", "", "JSON configuration file ($DEPBLEED_CONFIG)")
+	for _, f := range fields {
+		flags.Var(f.value, f.flag, f.usage+" ($"+f.env+")")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	//The flags take precedence; they are set again once the file and the
+	//environment are read
+	set := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	if *path == "" {
+		*path, _ = lookupEnv("DEPBLEED_CONFIG")
+	}
+	if *path != "" {
+		if err := c.readFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := lookupEnv(f.env); ok && value != "" {
+			if err := f.value.Set(value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for $%s: %v", value, f.env, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := set[f.flag]; ok {
+			f.value.Set(value)
+		}
+	}
+
+	if c.Workspace != "" {
+		workspace, err := filepath.Abs(c.Workspace)
+		if err != nil {
+			return nil, err
+		}
+		c.Workspace = workspace
+	}
+
+	return c, nil
+}
+
+//readFile sets the values found in the JSON file at path
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+//Print writes the configuration as JSON without the secrets
+func (c *Config) Print(w io.Writer) error {
+	body, err := json.MarshalIndent(c.Redacted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", body)
+	return err
+}
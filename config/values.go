@@ -0,0 +1,150 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Duration is a time.Duration written like "5m" in the files
+type Duration time.Duration
+
+//Set parses a duration like "5m"
+func (d *Duration) Set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+//MarshalJSON writes the duration like "5m0s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+//UnmarshalJSON reads a duration like "5m"
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("expected a duration like \"5m\"; got %s", b)
+	}
+	return d.Set(s)
+}
+
+//Size is a number of bytes written like "500MiB" in the files
+type Size int64
+
+//sizeUnits are the suffixes of the sizes, longest first
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"GB", 1000 * 1000 * 1000},
+	{"MB", 1000 * 1000},
+	{"KB", 1000},
+	{"B", 1},
+}
+
+//Set parses a size like "500MiB", or a number of bytes
+func (s *Size) Set(value string) error {
+	value = strings.TrimSpace(value)
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value, unit = strings.TrimSpace(strings.TrimSuffix(value, u.suffix)), u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("expected a size like \"500MiB\"; got %q", value)
+	}
+	*s = Size(n * unit)
+	return nil
+}
+
+func (s Size) String() string {
+	//The binary units come first
+	for _, u := range sizeUnits[:3] {
+		if s != 0 && int64(s)%u.bytes == 0 {
+			return strconv.FormatInt(int64(s)/u.bytes, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+//MarshalJSON writes the size like "500MiB"
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+//UnmarshalJSON reads a size like "500MiB" or a number of bytes
+func (s *Size) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("expected a size like \"500MiB\"; got %s", b)
+		}
+		*s = Size(n)
+		return nil
+	}
+	return s.Set(value)
+}
+
+//List is a list written comma separated in the environment and the flags
+type List []string
+
+//Set parses a comma separated list
+func (l *List) Set(value string) error {
+	*l = List{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func (l List) String() string {
+	return strings.Join(l, ",")
+}
+
+//intValue and stringValue are the flag values of the other fields
+type intValue int
+
+func (i *intValue) Set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expected an integer; got %q", value)
+	}
+	*i = intValue(n)
+	return nil
+}
+
+func (i *intValue) String() string {
+	return strconv.Itoa(int(*i))
+}
+
+type stringValue string
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
+
+func (s *stringValue) String() string {
+	return string(*s)
+}
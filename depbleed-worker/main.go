@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/depbleed/backend/analyzer"
+	"github.com/depbleed/backend/config"
+	"github.com/depbleed/backend/git"
+	"github.com/depbleed/backend/logger"
+	"github.com/depbleed/backend/worker"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+
+	//The type-checking runs in a subprocess of this binary
+	if len(os.Args) > 1 && os.Args[1] == "sandbox" {
+		if err := analyzer.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := config.Load("depbleed-worker", os.Args[1:], os.Stderr)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	if !cfg.Shared() {
+		fmt.Fprintf(os.Stderr, "the %s storage can't be shared; depbleed runs the workers itself\n", cfg.Storage)
+		os.Exit(2)
+	}
+
+	logger.SetDefault(cfg.Logger())
+	git.SetGithubToken(cfg.GithubToken)
+
+	store, err := cfg.OpenStore()
+
+	if err != nil {
+		logger.Error("Can't initialize the database", "err", err)
+		os.Exit(1)
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		logger.Error("Can't locate the sandbox executable", "err", err)
+		os.Exit(1)
+	}
+
+	pipeline, err := cfg.NewPipeline(executable, "sandbox")
+
+	if err != nil {
+		logger.Error("Can't initialize the repository cache", "err", err)
+		os.Exit(1)
+	}
+
+	//Remove the checkouts of a run that was killed
+	if err := pipeline.Cache.Clean(); err != nil {
+		logger.Warn("Can't clean the repository cache", "err", err)
+	}
+
+	hostname, _ := os.Hostname()
+
+	if cfg.MetricsPort != 0 {
+		//Workers aren't routed; the metrics are scraped on their own port
+		go func() {
+			err := http.ListenAndServe(":"+strconv.Itoa(cfg.MetricsPort), promhttp.Handler())
+			logger.Error("Can't serve the metrics", "err", err)
+		}()
+	}
+
+	stopping, stop := context.WithCancel(context.Background())
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		logger.Info("Shutting down", "signal", <-signals)
+		stop()
+	}()
+
+	var pruned <-chan struct{}
+	if cfg.Retention.Interval > 0 {
+		pruned = worker.Prune(stopping, time.Duration(cfg.Retention.Interval), store, cfg.RetentionPolicy())
+	}
+
+	//Each worker runs one job at a time
+	<-worker.Start(stopping, cfg.Workers, worker.Worker{
+		ID:           hostname + "-" + strconv.Itoa(os.Getpid()),
+		Persistence:  store,
+		Queue:        store,
+		Analyse:      pipeline.Analyse,
+		DrainTimeout: time.Duration(cfg.DrainTimeout),
+	})
+	if pruned != nil {
+		<-pruned
+	}
+
+	if err := pipeline.Cache.Clean(); err != nil {
+		logger.Warn("Can't clean the repository cache", "err", err)
+	}
+	store.Close()
+}
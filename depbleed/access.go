@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/depbleed/backend/logger"
+)
+
+//responseRecorder records the status and the size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+//withAccessLog is a middleware logging every request once it is served. It
+//runs after withRequestID.
+func withAccessLog(log *logger.Logger) func(http.Handler) http.Handler {
+	return func(inner http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w}
+
+			inner.ServeHTTP(recorder, r)
+
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+
+			level := log.Info
+			if recorder.status >= http.StatusInternalServerError {
+				level = log.Error
+			}
+
+			level("request",
+				"method", r.Method,
+				"path", r.URL.RequestURI(),
+				"status", recorder.status,
+				"bytes", recorder.bytes,
+				"latency", time.Since(start),
+				"request_id", requestID(r),
+				"ip", remoteIP(r),
+				"user_agent", r.UserAgent(),
+			)
+		})
+	}
+}
+
+//remoteIP returns the IP of the client. Behind Heroku's router, the client
+//is the last address of X-Forwarded-For as the router appends to the header
+//sent by the client.
+func remoteIP(r *http.Request) string {
+	if forwarded := strings.Join(r.Header["X-Forwarded-For"], ","); forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/depbleed/backend/analyzer"
+	"github.com/depbleed/backend/config"
+	"github.com/depbleed/backend/git"
+	"github.com/depbleed/backend/persistence"
+)
+
+//Exit codes of the analyze command
+const (
+	exitOK    = 0
+	exitLeaks = 1
+	exitError = 2
+)
+
+//analyzeCommand implements `depbleed analyze`. It runs the same pipeline as
+//the workers on a local package or a github repo and returns the exit code.
+func analyzeCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "text", "output format: text, json or document")
+	maxLeaks := flags.Int("max-leaks", 0, "exit with status 1 when there are more leaks than this")
+	commit := flags.String("commit", "", "commit to analyze for a github repo (default: last commit of master)")
+	language := flags.String("language", "", "language to analyze, like go (default: detected)")
+	builds := flags.String("builds", "", "comma separated GOOS/GOARCH, each followed by its build tags, to type-check for (default: the host one)")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: depbleed analyze [flags] <path-or-repo>")
+		fmt.Fprintln(stderr, "\nA path must be in GOPATH. A repo (user/repo or github.com/user/repo) is")
+		fmt.Fprintln(stderr, "checked out under ./repositories, which must be in GOPATH as well.")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitError
+	}
+	if *format != "text" && *format != "json" && *format != "document" {
+		fmt.Fprintf(stderr, "unknown format %q\n", *format)
+		return exitError
+	}
+
+	sandbox := analyzer.NewSandbox()
+	for _, b := range strings.Split(*builds, ",") {
+		if strings.TrimSpace(b) == "" {
+			continue
+		}
+		parsed, err := analyzer.ParseBuild(b)
+		if err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return exitError
+		}
+		sandbox.Builds = append(sandbox.Builds, parsed)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(stderr, "Can't locate the sandbox executable:", err.Error())
+		return exitError
+	}
+	sandbox.Command = []string{executable, "sandbox"}
+
+	pipeline := &analyzer.Pipeline{
+		Analyzers: analyzer.NewRegistry(&analyzer.GoAnalyzer{
+			Sandbox: sandbox,
+			GOPATH:  build.Default.GOPATH,
+		}),
+	}
+
+	target := flags.Arg(0)
+	url, analyzed, analysis, err := analyzeTarget(pipeline, target, strings.ToUpper(*language), *commit)
+	if err != nil {
+		fmt.Fprintln(stderr, "Can't analyse", target+":", err.Error())
+		return exitError
+	}
+
+	if err := printAnalysis(stdout, *format, url, analyzed, analysis); err != nil {
+		fmt.Fprintln(stderr, "Can't print analysis:", err.Error())
+		return exitError
+	}
+
+	if analysis.Status != persistence.StatusDone {
+		fmt.Fprintf(stderr, "analysis %s: %s\n", analysis.Status, analysis.Reason)
+		return exitError
+	}
+	if len(analysis.Leaks) > *maxLeaks {
+		return exitLeaks
+	}
+	return exitOK
+}
+
+//analyzeTarget analyzes a local directory, or checks out and analyzes a
+//github repo, in language or the detected one. It returns the repository
+//URL and the language with the analysis.
+func analyzeTarget(pipeline *analyzer.Pipeline, target string, language string, commit string) (string, string, *persistence.Analysis, error) {
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		dir, err := filepath.Abs(target)
+		if err != nil {
+			return "", "", nil, err
+		}
+		src := filepath.Join(build.Default.GOPATH, "src")
+		url, err := filepath.Rel(src, dir)
+		if err != nil || strings.HasPrefix(url, "..") {
+			return "", "", nil, fmt.Errorf("%s is not in GOPATH (%s)", dir, build.Default.GOPATH)
+		}
+		url = filepath.ToSlash(url)
+		//The files are named user/repo/... like in the cache, which
+		//checks out the repositories of its host
+		root := filepath.Join(src, strings.Split(url, "/")[0])
+		if commit == "" {
+			commit, _ = git.Head(context.Background(), dir)
+		}
+
+		language, analysis, err := pipeline.AnalyseDir(context.Background(), dir, root, language, commit)
+		return url, language, analysis, err
+	}
+
+	repo := strings.TrimPrefix(target, "github.com/")
+	if strings.Count(repo, "/") != 1 {
+		return "", "", nil, fmt.Errorf("%s is neither a directory nor a github repo", target)
+	}
+
+	wd, _ := os.Getwd()
+	absPath, _ := filepath.Abs(wd)
+	//The mirrors are bounded like the ones of the server
+	cache, err := git.NewCache(absPath+"/repositories", int64(config.Default().CacheSize), git.DefaultLimits)
+	if err != nil {
+		return "", "", nil, err
+	}
+	pipeline.Cache = cache
+
+	if commit == "" {
+		if commit, err = git.FetchLastCommit(context.Background(), repo); err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	language, analysis, err := pipeline.Analyse(context.Background(), repo, language, commit)
+	return "github.com/" + repo, language, analysis, err
+}
+
+//printAnalysis writes the analysis of the repository at url in format
+func printAnalysis(w io.Writer, format string, url string, language string, analysis *persistence.Analysis) error {
+	var body interface{}
+
+	switch format {
+	case "text":
+		for _, leak := range analysis.Leaks {
+			fmt.Fprintf(w, "%s:%d:%d: %s", leak.File, leak.Line, leak.Column, leak.Message)
+			if len(leak.Configurations) > 0 {
+				fmt.Fprintf(w, " (%s)", strings.Join(leak.Configurations, ", "))
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%d leaks in %s, score %.2f (%s)\n", len(analysis.Leaks), url, analysis.Score, analysis.Grade)
+		return nil
+	case "json":
+		body = analysis.Leaks
+	case "document":
+		//The document the API would store, scored like the analysis
+		body = persistence.Repository{
+			URL:      url,
+			Analysis: []*persistence.Analysis{analysis},
+			Language: language,
+			Score:    analysis.Score,
+			Grade:    analysis.Grade,
+		}
+	}
+
+	respBody, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(respBody))
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/depbleed/backend/analyzer"
+	"github.com/depbleed/backend/persistence"
+)
+
+func TestAnalyzeCommandUsage(t *testing.T) {
+
+	testCases := [][]string{
+		{},
+		{"-format", "xml", "depbleed/go"},
+		{"not-a-repo"},
+		{"-builds", "linux", "depbleed/go"},
+	}
+
+	for _, args := range testCases {
+		var stdout, stderr bytes.Buffer
+		if code := analyzeCommand(args, &stdout, &stderr); code != exitError {
+			t.Errorf("expected exit code %d for %v; got %d", exitError, args, code)
+		}
+	}
+}
+
+func TestPrintAnalysis(t *testing.T) {
+
+	analysis := &persistence.Analysis{
+		Hash:   "abc",
+		Status: persistence.StatusDone,
+		Score:  97.5,
+		Grade:  "A",
+		Leaks: []*persistence.Leak{
+			{File: "user/repo/a.go", Line: 3, Column: 6, Message: "F: leak"},
+			{File: "user/repo/a_windows.go", Line: 3, Column: 6, Message: "G: leak", Configurations: []string{"windows/amd64", "windows/386"}},
+		},
+	}
+
+	var text bytes.Buffer
+	printAnalysis(&text, "text", "github.com/user/repo", "GO", analysis)
+	expected := "user/repo/a.go:3:6: F: leak\nuser/repo/a_windows.go:3:6: G: leak (windows/amd64, windows/386)\n2 leaks in github.com/user/repo, score 97.50 (A)\n"
+	if text.String() != expected {
+		t.Errorf("expected %q; got %q", expected, text.String())
+	}
+
+	var document bytes.Buffer
+	printAnalysis(&document, "document", "github.com/user/repo", "GO", analysis)
+	var repository persistence.Repository
+	if err := json.Unmarshal(document.Bytes(), &repository); err != nil {
+		t.Fatal(err)
+	}
+	if repository.URL != "github.com/user/repo" || repository.Language != "GO" || repository.Latest().Hash != "abc" || repository.Score != 97.5 || repository.Grade != "A" {
+		t.Errorf("unexpected document %s", document.String())
+	}
+}
+
+//rootAnalyzer leaks a.go of every directory, named relative to the root
+//like the Go analyzer does
+type rootAnalyzer struct{}
+
+func (rootAnalyzer) Language() string {
+	return "GO"
+}
+
+func (rootAnalyzer) Detect(dir string) bool {
+	return true
+}
+
+func (rootAnalyzer) Analyse(ctx context.Context, dir string, root string) (analyzer.Result, error) {
+	file := strings.TrimPrefix(filepath.ToSlash(dir), filepath.ToSlash(root)+"/") + "/a.go"
+	return analyzer.Result{Leaks: []*persistence.Leak{{File: file}}}, nil
+}
+
+func TestAnalyzeTargetDir(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	dir := filepath.Join(gopath, "src", "github.com", "user", "repo")
+	os.MkdirAll(dir, 0755)
+
+	defer func(gopath string) {
+		build.Default.GOPATH = gopath
+	}(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+
+	pipeline := &analyzer.Pipeline{Analyzers: analyzer.NewRegistry(rootAnalyzer{})}
+	url, language, analysis, err := analyzeTarget(pipeline, dir, "", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "github.com/user/repo" || language != "GO" {
+		t.Errorf("expected github.com/user/repo in GO; got %s in %s", url, language)
+	}
+	//The API names the files the same
+	if file := analysis.Leaks[0].File; file != "user/repo/a.go" {
+		t.Errorf("expected user/repo/a.go; got %s", file)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/depbleed/backend/config"
+	"github.com/depbleed/backend/persistence"
+)
+
+//configCommand runs the depbleed config subcommands
+func configCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(stderr, "usage: depbleed config print [flags]")
+		fmt.Fprintln(stderr, "")
+		fmt.Fprintln(stderr, "Prints the configuration loaded from the flags, the environment and the")
+		fmt.Fprintln(stderr, "configuration file, with the secrets redacted.")
+		return exitError
+	}
+
+	cfg, err := config.Load("depbleed config print", args[1:], stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return exitError
+	}
+
+	if err := cfg.Print(stdout); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return exitError
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return exitError
+	}
+	return exitOK
+}
+
+//openStore parses args with the configuration flags and opens the
+//configured store. The store is nil when the command must exit with code.
+func openStore(flags *flag.FlagSet, args []string, stderr io.Writer) (*config.Config, persistence.Store, int) {
+	cfg, err := config.LoadFlags(flags, args)
+	if err == flag.ErrHelp {
+		return nil, nil, exitOK
+	} else if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return nil, nil, exitError
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return nil, nil, exitError
+	}
+
+	store, err := cfg.OpenStore()
+	if err != nil {
+		fmt.Fprintln(stderr, "Can't initialize the database:", err.Error())
+		return nil, nil, exitError
+	}
+	return cfg, store, exitOK
+}
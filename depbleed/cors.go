@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+//withAllowedHosts is a middleware refusing the requests for a host that
+//isn't allowed. Any host is allowed when hosts is empty.
+func withAllowedHosts(hosts []string) func(http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, host := range hosts {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	return func(inner http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+
+			if len(allowed) > 0 && !allowed[strings.ToLower(host)] {
+				ErrorWithJSON(w, r, http.StatusMisdirectedRequest, codeInvalidHost, "The API doesn't answer for "+r.Host, nil)
+				return
+			}
+			inner.ServeHTTP(w, r)
+		})
+	}
+}
+
+//withCORS is a middleware letting the browsers call the API from origins.
+//The origin * allows any origin.
+func withCORS(origins []string) func(http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return func(inner http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !(allowed["*"] || allowed[origin]) {
+				inner.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag")
+
+			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+				//Preflight
+				w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Accept, X-Request-ID, If-None-Match")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			inner.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+//freeDisk returns the bytes available to unprivileged users in dir
+func freeDisk(dir string) (uint64, error) {
+	return 0, errDiskUnsupported
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/depbleed/backend/logger"
+	"github.com/depbleed/backend/persistence"
+)
+
+//Error codes of the API, for the clients to tell the errors apart
+const (
+	codeRepoNotFound        = "repo_not_found"
+	codeAnalysisNotFound    = "analysis_not_found"
+	codeAnalysisFailed      = "analysis_failed"
+	codeUnsupportedLanguage = "unsupported_language"
+	codeLanguageMismatch    = "language_mismatch"
+	codeRateLimited         = "rate_limited"
+	codeGithubUnavailable   = "github_unavailable"
+	codeInvalidPagination   = "invalid_pagination"
+	codeInvalidSort         = "invalid_sort"
+	codeInvalidFormat       = "invalid_format"
+	codeNotAcceptable       = "not_acceptable"
+	codeInvalidHost         = "invalid_host"
+	codeStoreUnavailable    = "store_unavailable"
+	codeTimeout             = "timeout"
+	codeInternal            = "internal_error"
+)
+
+//apiError is the body of every error response
+type apiError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id"`
+}
+
+//ErrorWithJSON writes an error response with the request ID
+func ErrorWithJSON(w http.ResponseWriter, r *http.Request, status int, code string, message string, details interface{}) {
+	body, _ := json.MarshalIndent(apiError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestID(r),
+	}, "", "  ")
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+type requestIDKey struct{}
+
+//validRequestID matches the request IDs accepted from the clients and the
+//proxies
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+//withRequestID is a middleware giving each request an ID, taken from the
+//X-Request-ID header when there is a valid one
+func withRequestID(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			random := make([]byte, 8)
+			rand.Read(random)
+			id = hex.EncodeToString(random)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+//requestID returns the ID of a request
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+//storeRetryAfter is when, in seconds, the clients should retry once the
+//store is unavailable
+const storeRetryAfter = "5"
+
+//storeError writes the error of a store call made with ctx for r: 504 when
+//it timed out, 503 when the store can't be reached and 500 otherwise.
+//Nothing is written once the client went away.
+func storeError(ctx context.Context, w http.ResponseWriter, r *http.Request, message string, err error) {
+	switch {
+	case r.Context().Err() != nil:
+		logger.Debug(message, "err", err, "request_id", requestID(r))
+	case ctx.Err() == context.DeadlineExceeded:
+		logger.Warn(message, "err", err, "request_id", requestID(r))
+		ErrorWithJSON(w, r, http.StatusGatewayTimeout, codeTimeout, "The storage didn't answer in time, try again later", nil)
+	case persistence.IsUnavailable(err):
+		logger.Warn(message, "err", err, "request_id", requestID(r))
+		w.Header().Set("Retry-After", storeRetryAfter)
+		ErrorWithJSON(w, r, http.StatusServiceUnavailable, codeStoreUnavailable, "The storage is unavailable, try again later", nil)
+	default:
+		handleError(message, err, r, w)
+	}
+}
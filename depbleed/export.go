@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//dateValue is a time written like 2018-01-31 or 2018-01-31T12:00:00Z
+type dateValue time.Time
+
+//Set parses a date, in UTC when it has no time
+func (d *dateValue) Set(s string) error {
+	parsed, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		parsed, err = time.Parse(time.RFC3339, s)
+	}
+	if err != nil {
+		return errors.New("expected a date like 2018-01-31 or 2018-01-31T12:00:00Z")
+	}
+	*d = dateValue(parsed)
+	return nil
+}
+
+func (d *dateValue) String() string {
+	if d == nil || time.Time(*d).IsZero() {
+		return ""
+	}
+	return time.Time(*d).Format(time.RFC3339)
+}
+
+//filterFlags defines the flags of the filter of the export and import
+//commands in flags
+func filterFlags(flags *flag.FlagSet) *persistence.Filter {
+	filter := &persistence.Filter{}
+	flags.StringVar(&filter.Prefix, "prefix", "", "only the repositories whose URL starts with this, like github.com/depbleed/")
+	flags.Var((*dateValue)(&filter.Since), "since", "only the analyses made from this date on")
+	flags.Var((*dateValue)(&filter.Until), "until", "only the analyses made before this date")
+	return filter
+}
+
+//exportCommand implements `depbleed export`. It writes the stored analyses
+//as newline-delimited JSON.
+func exportCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	filter := filterFlags(flags)
+	output := flags.String("output", "", "file to write to (default: stdout)")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: depbleed export [-prefix prefix] [-since date] [-until date] [-output file] [flags]")
+		fmt.Fprintln(stderr, "\nWrites the analyses of the store as JSON, one per line, along with their")
+		fmt.Fprintln(stderr, "repository.")
+		flags.PrintDefaults()
+	}
+
+	_, store, code := openStore(flags, args, stderr)
+	if store == nil {
+		return code
+	}
+	defer store.Close()
+
+	w := stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return exitError
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := persistence.Export(context.Background(), store, w, *filter)
+	if err != nil {
+		fmt.Fprintln(stderr, "Can't export:", err.Error())
+		return exitError
+	}
+	fmt.Fprintf(stderr, "exported %d analyses\n", count)
+	return exitOK
+}
+
+//importCommand implements `depbleed import`. It appends the exported
+//analyses to the store; the ones already stored are skipped.
+func importCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	filter := filterFlags(flags)
+	input := flags.String("input", "", "file to read from (default: stdin)")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: depbleed import [-prefix prefix] [-since date] [-until date] [-input file] [flags]")
+		fmt.Fprintln(stderr, "\nAppends the analyses written by depbleed export to the store. The analyses")
+		fmt.Fprintln(stderr, "already stored are skipped so that an import can be run again.")
+		flags.PrintDefaults()
+	}
+
+	_, store, code := openStore(flags, args, stderr)
+	if store == nil {
+		return code
+	}
+	defer store.Close()
+
+	r := stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return exitError
+		}
+		defer file.Close()
+		r = file
+	}
+
+	count, err := persistence.Import(context.Background(), store, r, *filter)
+	fmt.Fprintf(stdout, "imported %d analyses\n", count)
+	if err != nil {
+		fmt.Fprintln(stderr, "Can't import:", err.Error())
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+//minFreeDisk is the free space the workspace needs for the checkouts
+const minFreeDisk = 512 << 20
+
+//checkTimeout is how long a readiness check has to pass
+const checkTimeout = 2 * time.Second
+
+//errDiskUnsupported is returned where the free disk space can't be known
+var errDiskUnsupported = errors.New("free disk space is unknown on this platform")
+
+//check is one of the readiness checks
+type check struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+//checkResult is the outcome of a check in the /readyz breakdown
+type checkResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+//readiness is the body of /readyz
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+//healthz answers as long as the process serves requests
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+//readyz runs the checks and answers 503 unless they all pass
+func readyz(checks []check) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := readiness{Status: "ok", Checks: map[string]checkResult{}}
+		code := http.StatusOK
+
+		for _, check := range checks {
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			err := check.run(ctx)
+			cancel()
+
+			result := checkResult{Status: "ok", Duration: time.Since(start).String()}
+			if err != nil {
+				result.Status, result.Error = "failing", err.Error()
+				body.Status, code = "unavailable", http.StatusServiceUnavailable
+			}
+			body.Checks[check.name] = result
+		}
+
+		respBody, _ := json.MarshalIndent(body, "", "  ")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		w.Write(respBody)
+	}
+}
+
+//commandCheck checks that name is on PATH
+func commandCheck(name string) check {
+	return check{name: name, run: func(ctx context.Context) error {
+		_, err := exec.LookPath(name)
+		return err
+	}}
+}
+
+//diskCheck checks that dir has minFreeDisk available
+func diskCheck(dir string) check {
+	return check{name: "disk", run: func(ctx context.Context) error {
+		free, err := freeDisk(dir)
+		if err != nil {
+			return err
+		}
+		if free < minFreeDisk {
+			return fmt.Errorf("%d MiB free in %s, %d MiB needed", free>>20, dir, minFreeDisk>>20)
+		}
+		return nil
+	}}
+}
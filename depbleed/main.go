@@ -1,209 +1,614 @@
-package main
-
-import (
-	"encoding/json"
-	"fmt"
-	"go/build"
-	"net/http"
-	"os"
-	"path/filepath"
-	"strconv"
-	"time"
-
-	"github.com/depbleed/backend/git"
-	"github.com/depbleed/backend/persistence"
-	depbleed "github.com/depbleed/go/go-depbleed"
-
-	goji "goji.io"
-
-	"strings"
-
-	"goji.io/pat"
-)
-
-func ErrorWithJSON(w http.ResponseWriter, message string, code int) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(code)
-	fmt.Fprintf(w, "{message: %q}", message)
-}
-
-func ResponseWithJSON(w http.ResponseWriter, json []byte, code int) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(code)
-	w.Write(json)
-}
-
-type backend struct {
-	persistence persistence.DAO
-}
-
-func main() {
-
-	persistence, err := persistence.NewMongo()
-
-	if err != nil {
-		fmt.Println("Can't initialize the database")
-		panic(err.Error())
-	}
-
-	backend := &backend{
-		persistence: persistence,
-	}
-
-	if os.Getenv("PORT") == "" {
-		os.Setenv("PORT", "80")
-	}
-
-	fmt.Println("Serving on localhost", os.Getenv("PORT"))
-
-	mux := goji.NewMux()
-	mux.HandleFunc(pat.Get("/leaks/go/:user/:repo"), analyse(backend))
-	mux.HandleFunc(pat.Get("/leaks/go/all/:skip/:limit"), allRepositories(backend))
-	http.ListenAndServe(":"+os.Getenv("PORT"), mux)
-}
-
-func log(ip string, time string, method string, path string, code string, elasped string) {
-
-	fmt.Printf(
-		"%s [%s] \"%s %s\" %s (%s)\n",
-		ip, time, method, path, code, elasped)
-}
-
-func analyse(b *backend) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		start := time.Now()
-
-		user := pat.Param(r, "user")
-		repo := pat.Param(r, "repo")
-
-		var repository persistence.Repository
-		lastCommit := git.FetchLastCommit(user + "/" + repo)
-		repository, err := b.persistence.FindRepo("github.com/" + user + "/" + repo)
-
-		if err != nil {
-			//Didn't find this repo; insert it
-			repository = persistence.Repository{
-				URL:      "github.com/" + user + "/" + repo,
-				Language: "GO",
-			}
-			b.persistence.InsertRepo(repository)
-
-		} else if len(repository.Analysis) != 0 && repository.Analysis[0].Hash == lastCommit {
-			//This repo is up to date; return the last analyse
-			respBody, err := json.MarshalIndent(repository, "", "  ")
-			if err != nil {
-				handleErrorRepo("Can't marshall repository", err, start, user, repo, r, w)
-				return
-			}
-			log(r.RemoteAddr, time.Now().Format(time.RFC1123), "GET", "/leaks/go/"+user+"/"+repo, "200", time.Since(start).String())
-			ResponseWithJSON(w, respBody, http.StatusOK)
-			return
-		}
-
-		//TODO: Determine repo is currently being analyzed
-
-		git.CloneRepo(user + "/" + repo)
-
-		analysis := &persistence.Analysis{
-			Hash:  lastCommit,
-			Leaks: []*persistence.Leak{},
-			Time:  time.Now().Unix(),
-		}
-		runAnalysis(analysis, user, repo)
-
-		//Append the analysis
-		repository.Analysis = append(repository.Analysis, analysis)
-
-		err = b.persistence.UpdateRepo(repository)
-		if err != nil {
-			handleErrorRepo("Can't update repository", err, start, user, repo, r, w)
-			return
-		}
-
-		respBody, err := json.MarshalIndent(repository, "", "  ")
-		if err != nil {
-			handleErrorRepo("Can't marshall repo", err, start, user, repo, r, w)
-			return
-		}
-
-		git.DeleteRepo(user)
-
-		log(r.RemoteAddr, time.Now().Format(time.RFC1123), "GET", "/leaks/go/"+user+"/"+repo, "200", time.Since(start).String())
-		ResponseWithJSON(w, respBody, http.StatusOK)
-		return
-	}
-}
-
-func handleErrorRepo(errString string, err error, start time.Time, user string, repo string, r *http.Request, w http.ResponseWriter) {
-	fmt.Println(errString, err.Error())
-	log(r.RemoteAddr, time.Now().Format(time.RFC1123), "GET", "/leaks/go/"+user+"/"+repo, "500", time.Since(start).String())
-	ErrorWithJSON(w, "Something went wrong", 500)
-	git.DeleteRepo(user)
-}
-
-func runAnalysis(analysis *persistence.Analysis, user string, repo string) {
-	wd, _ := os.Getwd()
-	//Get paths
-	absPath, _ := filepath.Abs(wd)
-	gopath := build.Default.GOPATH
-	packagePath, _ := depbleed.GetPackagePath(gopath, absPath+"/repositories/"+user+"/"+repo)
-	packageInfo, _ := depbleed.GetPackageInfo(packagePath)
-
-	fmt.Println(absPath, gopath, packagePath)
-
-	//Compute leaks
-	leaks := packageInfo.Leaks()
-	for _, leak := range leaks {
-
-		relPath, _ := filepath.Rel(wd, leak.Position.Filename)
-		relPath = strings.Replace(leak.Position.Filename, absPath+"/repositories/", "", 1)
-
-		//Append all the leaks
-		analysis.Leaks = append(analysis.Leaks, &persistence.Leak{
-			Message: strings.Replace(leak.Error(), "github.com/depbleed/backend/repositories/", "", 1),
-			Column:  leak.Position.Column,
-			Line:    leak.Position.Line,
-			File:    relPath,
-		})
-
-	}
-}
-
-func allRepositories(b *backend) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		start := time.Now()
-
-		skip := pat.Param(r, "skip")
-		limit := pat.Param(r, "limit")
-
-		skipInt, errSkip := strconv.Atoi(skip)
-		limitInt, errlimit := strconv.Atoi(limit)
-
-		if errSkip != nil || errlimit != nil {
-			fmt.Println("Can't convert to ints", errSkip.Error(), errlimit.Error())
-			log(r.RemoteAddr, time.Now().Format(time.RFC1123), "GET", "/leaks/all/"+skip+"/"+limit, "500", time.Since(start).String())
-			ErrorWithJSON(w, "Skip & Limit are expected to be ints", 500)
-			return
-		}
-
-		repos, err := b.persistence.FindAll(skipInt, limitInt)
-
-		if err != nil {
-			fmt.Println("Can't fetch repos", err.Error())
-			log(r.RemoteAddr, time.Now().Format(time.RFC1123), "GET", "/leaks/all/"+skip+"/"+limit, "500", time.Since(start).String())
-			ErrorWithJSON(w, "Something went wrong", 500)
-		}
-
-		respBody, err := json.MarshalIndent(repos, "", "  ")
-		if err != nil {
-			fmt.Println("Can't marshall", err.Error())
-			log(r.RemoteAddr, time.Now().Format(time.RFC1123), "GET", "/leaks/all/"+skip+"/"+limit, "500", time.Since(start).String())
-			ErrorWithJSON(w, "Something went wrong", 500)
-			return
-		}
-
-		ResponseWithJSON(w, respBody, http.StatusOK)
-	}
-}
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/depbleed/backend/analyzer"
+	"github.com/depbleed/backend/config"
+	"github.com/depbleed/backend/git"
+	"github.com/depbleed/backend/logger"
+	"github.com/depbleed/backend/persistence"
+	"github.com/depbleed/backend/render"
+	"github.com/depbleed/backend/worker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	goji "goji.io"
+
+	"goji.io/pat"
+)
+
+//maxLimit is the maximum number of repositories listed at once
+const maxLimit = 100
+
+type backend struct {
+	persistence persistence.DAO
+	queue       persistence.Queue
+	//languages are the languages analyzed, like GO
+	languages []string
+	//lastCommit fetches the last commit of a repo (user/repo)
+	lastCommit func(ctx context.Context, repo string) (string, error)
+	//storeTimeout and githubTimeout bound the calls of a request to the
+	//store and to github; they aren't bounded when 0
+	storeTimeout  time.Duration
+	githubTimeout time.Duration
+	//checks are run by /readyz
+	checks []check
+	//allowedHosts are the hosts the API answers for; any host if empty
+	allowedHosts []string
+	//corsOrigins are the origins allowed to call the API from a browser
+	corsOrigins []string
+}
+
+func main() {
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sandbox":
+			//The type-checking runs in a subprocess of this binary
+			if err := analyzer.Serve(os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			return
+		case "analyze":
+			os.Exit(analyzeCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "config":
+			os.Exit(configCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "prune":
+			os.Exit(pruneCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "export":
+			os.Exit(exportCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "import":
+			os.Exit(importCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "migrate":
+			os.Exit(migrateCommand(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
+	cfg, err := config.Load("depbleed", os.Args[1:], os.Stderr)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	logger.SetDefault(cfg.Logger())
+	git.SetGithubToken(cfg.GithubToken)
+
+	if err := os.MkdirAll(cfg.Workspace, 0755); err != nil {
+		logger.Error("Can't create the workspace", "err", err)
+		os.Exit(1)
+	}
+
+	store, err := cfg.OpenStore()
+
+	if err != nil {
+		logger.Error("Can't initialize the database", "err", err)
+		os.Exit(1)
+	}
+
+	backend := &backend{
+		persistence: store,
+		queue:       store,
+		languages:   cfg.NewAnalyzers().Languages(),
+		lastCommit:  git.FetchLastCommit,
+		checks: []check{
+			{name: cfg.Storage, run: store.Ping},
+			commandCheck("git"),
+			commandCheck("go"),
+			diskCheck(cfg.Workspace),
+		},
+		allowedHosts:  cfg.AllowedHosts,
+		corsOrigins:   cfg.CORSOrigins,
+		storeTimeout:  time.Duration(cfg.StoreTimeout),
+		githubTimeout: time.Duration(cfg.GithubTimeout),
+	}
+
+	prometheus.MustRegister(queueDepth(backend.queue))
+
+	server := &http.Server{
+		Addr:    ":" + strconv.Itoa(cfg.Port),
+		Handler: newMux(backend),
+	}
+
+	//The stores that can't be shared are served by workers of this process
+	stopping, stop := context.WithCancel(context.Background())
+	workers := make(chan struct{})
+	close(workers)
+	if !cfg.Shared() {
+		workers = startWorkers(stopping, cfg, store)
+	}
+
+	go func() {
+		logger.Info("Serving", "port", cfg.Port)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Error("Can't serve", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	//Heroku sends SIGTERM on restarts and SIGKILL 30s later
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	logger.Info("Shutting down", "signal", <-signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("Can't drain the requests", "err", err)
+	}
+	stop()
+	<-workers
+	store.Close()
+}
+
+//startWorkers runs the configured workers until stopping is done. The
+//returned channel is closed once they all returned.
+func startWorkers(stopping context.Context, cfg *config.Config, store persistence.Store) chan struct{} {
+	executable, err := os.Executable()
+
+	if err != nil {
+		logger.Error("Can't locate the sandbox executable", "err", err)
+		os.Exit(1)
+	}
+
+	pipeline, err := cfg.NewPipeline(executable, "sandbox")
+
+	if err != nil {
+		logger.Error("Can't initialize the repository cache", "err", err)
+		os.Exit(1)
+	}
+
+	//Remove the checkouts of a run that was killed
+	if err := pipeline.Cache.Clean(); err != nil {
+		logger.Warn("Can't clean the repository cache", "err", err)
+	}
+
+	hostname, _ := os.Hostname()
+	done := make(chan struct{})
+	stopped := worker.Start(stopping, cfg.Workers, worker.Worker{
+		ID:           hostname + "-" + strconv.Itoa(os.Getpid()),
+		Persistence:  store,
+		Queue:        store,
+		Analyse:      pipeline.Analyse,
+		DrainTimeout: time.Duration(cfg.DrainTimeout),
+	})
+
+	//Without other workers, this process prunes as well
+	var pruned <-chan struct{}
+	if cfg.Retention.Interval > 0 {
+		pruned = worker.Prune(stopping, time.Duration(cfg.Retention.Interval), store, cfg.RetentionPolicy())
+	}
+
+	go func() {
+		<-stopped
+		if pruned != nil {
+			<-pruned
+		}
+		if err := pipeline.Cache.Clean(); err != nil {
+			logger.Warn("Can't clean the repository cache", "err", err)
+		}
+		close(done)
+	}()
+	return done
+}
+
+//newMux returns the routes of the API
+func newMux(backend *backend) *goji.Mux {
+	mux := goji.NewMux()
+	mux.Use(withRequestID)
+	mux.Use(withAccessLog(logger.Default()))
+	mux.Use(withAllowedHosts(backend.allowedHosts))
+	mux.Use(withCORS(backend.corsOrigins))
+	handle(mux, "/leaks/:language/:user/:repo", analyse(backend))
+	handle(mux, "/leaks/:language/all/:skip/:limit", allRepositories(backend))
+	handle(mux, "/leaks/:language/:user/:repo/:hash", analysisByHash(backend))
+	handle(mux, "/badge/:language/:user/:repo.svg", badge(backend))
+	mux.Handle(pat.Get("/metrics"), promhttp.Handler())
+	mux.HandleFunc(pat.Get("/healthz"), healthz)
+	mux.HandleFunc(pat.Get("/readyz"), readyz(backend.checks))
+	return mux
+}
+
+//handle routes the GET requests of route to handler
+func handle(mux *goji.Mux, route string, handler http.HandlerFunc) {
+	mux.HandleFunc(pat.Get(route), instrument(route, handler))
+}
+
+func analyse(b *backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		user := pat.Param(r, "user")
+		repo := pat.Param(r, "repo")
+
+		renderer := negotiate(w, r)
+		if renderer == nil {
+			return
+		}
+		language, ok := supported(b, w, r)
+		if !ok {
+			return
+		}
+
+		url := "github.com/" + user + "/" + repo
+		ctx, cancel := withTimeout(r, b.githubTimeout)
+		lastCommit, err := b.lastCommit(ctx, user+"/"+repo)
+		cancel()
+
+		switch {
+		case err == git.ErrRepoNotFound:
+			ErrorWithJSON(w, r, http.StatusNotFound, codeRepoNotFound, "Repository "+url+" not found", nil)
+			return
+		case err == git.ErrRateLimited:
+			ErrorWithJSON(w, r, http.StatusTooManyRequests, codeRateLimited, "Github rate limit exceeded, try again later", nil)
+			return
+		case err != nil && r.Context().Err() != nil:
+			//The client went away
+			return
+		case err != nil && ctx.Err() == context.DeadlineExceeded:
+			logger.Warn("Can't fetch last commit", "repo", user+"/"+repo, "err", err, "request_id", requestID(r))
+			ErrorWithJSON(w, r, http.StatusGatewayTimeout, codeGithubUnavailable, "Github didn't answer in time, try again later", nil)
+			return
+		case err != nil:
+			logger.Warn("Can't fetch last commit", "repo", user+"/"+repo, "err", err, "request_id", requestID(r))
+			ErrorWithJSON(w, r, http.StatusBadGateway, codeGithubUnavailable, "Can't reach github", nil)
+			return
+		}
+
+		ctx, cancel = withTimeout(r, b.storeTimeout)
+		defer cancel()
+		repository, err := b.persistence.FindRepo(ctx, url)
+
+		if err != nil && err != persistence.ErrNotFound {
+			storeError(ctx, w, r, "Can't find repository", err)
+			return
+		} else if err != nil {
+			//Didn't find this repo; the worker inserts it
+			repository = persistence.Repository{
+				URL:      url,
+				Analysis: []*persistence.Analysis{},
+				Language: language,
+			}
+		} else if !analyzedAs(w, r, repository, language) {
+			return
+		} else {
+			touch(ctx, b, r, repository)
+		}
+
+		if latest := repository.Latest(); latest != nil && latest.Hash == lastCommit {
+			//This repo is up to date; return the last analyse
+			analysisRequests.WithLabelValues("up_to_date").Inc()
+			if err := respondRepository(w, r, renderer, repository, http.StatusOK); err != nil {
+				handleError("Can't marshall repository", err, r, w)
+				return
+			}
+			return
+		}
+
+		//Queue the analysis; a worker appends it to the repo
+		err = b.queue.EnqueueJob(ctx, user+"/"+repo, language, lastCommit)
+		if err != nil {
+			storeError(ctx, w, r, "Can't enqueue analysis", err)
+			return
+		}
+		analysisRequests.WithLabelValues("queued").Inc()
+
+		if err := respondRepository(w, r, renderer, repository, http.StatusAccepted); err != nil {
+			handleError("Can't marshall repo", err, r, w)
+			return
+		}
+
+		return
+	}
+}
+
+func analysisByHash(b *backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		user := pat.Param(r, "user")
+		repo := pat.Param(r, "repo")
+		hash := pat.Param(r, "hash")
+
+		renderer := negotiate(w, r)
+		if renderer == nil {
+			return
+		}
+		language, ok := supported(b, w, r)
+		if !ok {
+			return
+		}
+
+		respondAnalysis(b, w, r, renderer, language, user, repo, hash)
+	}
+}
+
+//respondAnalysis writes the analysis of commit hash of user/repo with
+//renderer
+func respondAnalysis(b *backend, w http.ResponseWriter, r *http.Request, renderer render.Renderer, language string, user string, repo string, hash string) {
+	ctx, cancel := withTimeout(r, b.storeTimeout)
+	defer cancel()
+	repository, err := b.persistence.FindRepo(ctx, "github.com/"+user+"/"+repo)
+
+	if err != nil && err != persistence.ErrNotFound {
+		storeError(ctx, w, r, "Can't find repository", err)
+		return
+	} else if err != nil {
+		ErrorWithJSON(w, r, http.StatusNotFound, codeRepoNotFound, "Repository github.com/"+user+"/"+repo+" was never analyzed", nil)
+		return
+	} else if !analyzedAs(w, r, repository, language) {
+		return
+	}
+
+	for _, analysis := range repository.Analysis {
+		if analysis.Hash != hash {
+			continue
+		}
+		if failed(w, r, renderer, analysis) {
+			return
+		}
+		respBody, err := renderer.Analysis(repository, analysis)
+		if err != nil {
+			handleError("Can't marshall analysis", err, r, w)
+			return
+		}
+		respond(w, renderer, respBody, http.StatusOK)
+		return
+	}
+
+	ErrorWithJSON(w, r, http.StatusNotFound, codeAnalysisNotFound, "No analysis of commit "+hash, nil)
+}
+
+//badgeMaxAge is how long, in seconds, the badges may be cached
+const badgeMaxAge = 300
+
+//touchInterval is how often the request time of a repository is recorded
+const touchInterval = 24 * time.Hour
+
+//badge serves the leak count of the latest stored analysis as an SVG badge.
+//It never triggers an analysis.
+func badge(b *backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		user := pat.Param(r, "user")
+		repo := pat.Param(r, "repo")
+		language, ok := supported(b, w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := withTimeout(r, b.storeTimeout)
+		defer cancel()
+		repository, err := b.persistence.FindRepo(ctx, "github.com/"+user+"/"+repo)
+		if err != nil && err != persistence.ErrNotFound {
+			storeError(ctx, w, r, "Can't find repository", err)
+			return
+		} else if err != nil || repository.Language != language {
+			//Never analysed in language; the badge shows an unknown state
+			repository = persistence.Repository{}
+		} else {
+			touch(ctx, b, r, repository)
+		}
+
+		etag := `"unknown"`
+		if latest := repository.Latest(); latest != nil {
+			etag = `"` + latest.Hash + `"`
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(badgeMaxAge))
+		w.Header().Set("ETag", etag)
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", render.BadgeContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(render.LeaksBadge(repository))
+	}
+}
+
+//touch records that repository was requested, for the retention. It is
+//recorded once per touchInterval at most so that the reads rarely write.
+func touch(ctx context.Context, b *backend, r *http.Request, repository persistence.Repository) {
+	now := time.Now()
+	if now.Sub(time.Unix(repository.Requested, 0)) < touchInterval {
+		return
+	}
+	if err := b.persistence.TouchRepo(ctx, repository.URL, now.Unix()); err != nil && err != persistence.ErrNotFound {
+		logger.Warn("Can't record the request", "repo", repository.URL, "err", err, "request_id", requestID(r))
+	}
+}
+
+//supported returns the language of the route, like GO for go. When it isn't
+//analyzed, the error is written and false is returned.
+func supported(b *backend, w http.ResponseWriter, r *http.Request) (string, bool) {
+	language := strings.ToUpper(pat.Param(r, "language"))
+	for _, analyzed := range b.languages {
+		if analyzed == language {
+			return language, true
+		}
+	}
+
+	languages := make([]string, len(b.languages))
+	for i, analyzed := range b.languages {
+		languages[i] = strings.ToLower(analyzed)
+	}
+	ErrorWithJSON(w, r, http.StatusNotFound, codeUnsupportedLanguage, "Language "+pat.Param(r, "language")+" isn't supported", languages)
+	return "", false
+}
+
+//analyzedAs tells whether repository is analyzed in language. The error is
+//written otherwise, since a repository is analyzed in a single language.
+func analyzedAs(w http.ResponseWriter, r *http.Request, repository persistence.Repository, language string) bool {
+	//The repositories stored without a language take the one of their
+	//next analysis
+	if repository.Language == language || repository.Language == "" {
+		return true
+	}
+	ErrorWithJSON(w, r, http.StatusConflict, codeLanguageMismatch, "Repository "+repository.URL+" is analyzed as "+strings.ToLower(repository.Language), nil)
+	return false
+}
+
+//negotiate returns the renderer requested with ?format= or the Accept
+//header. When the format isn't supported, the error is written and a nil
+//renderer is returned.
+func negotiate(w http.ResponseWriter, r *http.Request) render.Renderer {
+	renderer, code, err := render.Negotiate(r)
+	if err != nil {
+		errCode := codeInvalidFormat
+		if code == http.StatusNotAcceptable {
+			errCode = codeNotAcceptable
+		}
+		ErrorWithJSON(w, r, code, errCode, err.Error(), render.Formats())
+	}
+	return renderer
+}
+
+//failed writes an error when an analysis that didn't complete is requested
+//in a format only showing leaks, where it would look like a clean one
+func failed(w http.ResponseWriter, r *http.Request, renderer render.Renderer, analysis *persistence.Analysis) bool {
+	if _, ok := renderer.(render.JSONRenderer); ok || analysis.Status == "" || analysis.Status == persistence.StatusDone {
+		return false
+	}
+	ErrorWithJSON(w, r, http.StatusUnprocessableEntity, codeAnalysisFailed, "The analysis was "+analysis.Status, map[string]string{
+		"hash":   analysis.Hash,
+		"reason": analysis.Reason,
+	})
+	return true
+}
+
+//queuedRepository is the body of a repository being analyzed for the
+//formats only showing analyses
+type queuedRepository struct {
+	URL     string `json:"url"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+//respondRepository writes the repository with renderer
+func respondRepository(w http.ResponseWriter, r *http.Request, renderer render.Renderer, repository persistence.Repository, code int) error {
+	if latest := repository.Latest(); latest != nil && failed(w, r, renderer, latest) {
+		return nil
+	}
+
+	respBody, err := renderer.Repository(repository)
+	if err == render.ErrNoAnalysis {
+		respBody, err = json.MarshalIndent(queuedRepository{
+			URL:     repository.URL,
+			Status:  persistence.JobQueued,
+			Message: "The repository is being analyzed, try again later",
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		respond(w, render.JSONRenderer{}, respBody, code)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	respond(w, renderer, respBody, code)
+	return nil
+}
+
+//respond writes a body rendered by renderer
+func respond(w http.ResponseWriter, renderer render.Renderer, body []byte, code int) {
+	w.Header().Set("Content-Type", renderer.ContentType())
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+//withTimeout returns the context of a call made for r, bounded by timeout
+//unless it is 0
+func withTimeout(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+//handleError logs err and writes an internal error
+func handleError(errString string, err error, r *http.Request, w http.ResponseWriter) {
+	logger.Error(errString, "err", err, "request_id", requestID(r))
+	ErrorWithJSON(w, r, http.StatusInternalServerError, codeInternal, "Something went wrong", nil)
+}
+
+//ownedByAll tells whether the github user all has the repository repo
+func ownedByAll(b *backend, r *http.Request, repo string) bool {
+	ctx, cancel := withTimeout(r, b.storeTimeout)
+	defer cancel()
+	_, err := b.persistence.FindRepo(ctx, "github.com/all/"+repo)
+	return err == nil
+}
+
+//allRepositories lists the repositories analyzed in the language of the
+//route
+func allRepositories(b *backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		skip := pat.Param(r, "skip")
+		limit := pat.Param(r, "limit")
+
+		renderer := negotiate(w, r)
+		if renderer == nil {
+			return
+		}
+		language, ok := supported(b, w, r)
+		if !ok {
+			return
+		}
+
+		skipInt, errSkip := strconv.Atoi(skip)
+		limitInt, errlimit := strconv.Atoi(limit)
+
+		if errSkip != nil || errlimit != nil || skipInt < 0 || limitInt < 1 || limitInt > maxLimit {
+			//The route is shared with the analyses of the repositories of the
+			//github user all, whose commit hashes are no valid limits
+			if ownedByAll(b, r, skip) {
+				respondAnalysis(b, w, r, renderer, language, "all", skip, limit)
+				return
+			}
+			ErrorWithJSON(w, r, http.StatusBadRequest, codeInvalidPagination, "Skip & Limit are expected to be ints, with 0 <= skip and 1 <= limit <= "+strconv.Itoa(maxLimit), map[string]string{
+				"skip":  skip,
+				"limit": limit,
+			})
+			return
+		}
+
+		sort := r.URL.Query().Get("sort")
+		if !persistence.ValidSort(sort) {
+			ErrorWithJSON(w, r, http.StatusBadRequest, codeInvalidSort, "Sort is expected to be url or score, optionally prefixed with -", nil)
+			return
+		}
+
+		ctx, cancel := withTimeout(r, b.storeTimeout)
+		defer cancel()
+		repos, err := b.persistence.FindAll(ctx, language, skipInt, limitInt, sort)
+
+		if err != nil {
+			storeError(ctx, w, r, "Can't fetch repos", err)
+			return
+		}
+
+		respBody, err := renderer.Repositories(repos)
+		if err != nil {
+			logger.Error("Can't marshall repos", "err", err, "request_id", requestID(r))
+			ErrorWithJSON(w, r, http.StatusInternalServerError, codeInternal, "Something went wrong", nil)
+			return
+		}
+
+		respond(w, renderer, respBody, http.StatusOK)
+	}
+}
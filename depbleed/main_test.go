@@ -1,44 +1,758 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/depbleed/backend/git"
+	"github.com/depbleed/backend/logger"
 	"github.com/depbleed/backend/persistence"
+	"github.com/depbleed/backend/render"
+	"github.com/depbleed/backend/worker"
+	"github.com/prometheus/client_golang/prometheus"
+
+	goji "goji.io"
+	"goji.io/pat"
 )
 
-func TestLog(t *testing.T) {
+//newBackend returns a backend keeping everything in memory
+func newBackend() *backend {
+	store := persistence.NewMemory()
+	return &backend{persistence: store, queue: store, languages: []string{"GO"}}
+}
+
+func TestErrorResponses(t *testing.T) {
+
+	store := persistence.NewMemory()
+	if _, err := store.AppendAnalysis(context.Background(), "github.com/depbleed/go", "GO", &persistence.Analysis{Hash: "def"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AppendAnalysis(context.Background(), "github.com/depbleed/py", "PYTHON", &persistence.Analysis{Hash: "def"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &backend{
+		persistence: store,
+		queue:       store,
+		languages:   []string{"GO"},
+		lastCommit: func(ctx context.Context, repo string) (string, error) {
+			switch repo {
+			case "depbleed/missing":
+				return "", git.ErrRepoNotFound
+			case "depbleed/limited":
+				return "", git.ErrRateLimited
+			}
+			return "", errors.New("unreachable")
+		},
+	}
+	mux := newMux(b)
+
+	testCases := []struct {
+		path   string
+		accept string
+		status int
+		code   string
+	}{
+		{"/leaks/go/all/a/10", "", http.StatusBadRequest, codeInvalidPagination},
+		{"/leaks/go/all/-1/10", "", http.StatusBadRequest, codeInvalidPagination},
+		{"/leaks/go/all/0/0", "", http.StatusBadRequest, codeInvalidPagination},
+		{"/leaks/go/all/0/1000", "", http.StatusBadRequest, codeInvalidPagination},
+		{"/leaks/go/all/0/10?sort=leaks", "", http.StatusBadRequest, codeInvalidSort},
+		{"/leaks/go/all/0/10?format=pdf", "", http.StatusBadRequest, codeInvalidFormat},
+		{"/leaks/go/all/0/10", "image/png", http.StatusNotAcceptable, codeNotAcceptable},
+		{"/leaks/go/depbleed/missing", "", http.StatusNotFound, codeRepoNotFound},
+		{"/leaks/go/depbleed/limited", "", http.StatusTooManyRequests, codeRateLimited},
+		{"/leaks/go/depbleed/down", "", http.StatusBadGateway, codeGithubUnavailable},
+		{"/leaks/go/depbleed/go/abc", "", http.StatusNotFound, codeAnalysisNotFound},
+		{"/leaks/rust/depbleed/go", "", http.StatusNotFound, codeUnsupportedLanguage},
+		{"/leaks/rust/all/0/10", "", http.StatusNotFound, codeUnsupportedLanguage},
+		{"/leaks/go/depbleed/py/def", "", http.StatusConflict, codeLanguageMismatch},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			r := httptest.NewRequest("GET", testCase.path, nil)
+			if testCase.accept != "" {
+				r.Header.Set("Accept", testCase.accept)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != testCase.status {
+				t.Errorf("expected status %d; got %d", testCase.status, w.Code)
+			}
+
+			var body apiError
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected a JSON body; got %s", w.Body.String())
+			}
+			if body.Code != testCase.code {
+				t.Errorf("expected code %s; got %s", testCase.code, body.Code)
+			}
+			if body.RequestID == "" || body.RequestID != w.Header().Get("X-Request-ID") {
+				t.Errorf("expected the request ID %s; got %s", w.Header().Get("X-Request-ID"), body.RequestID)
+			}
+		})
+	}
+}
+
+func TestListingLanguage(t *testing.T) {
+	b := newBackend()
+	for url, language := range map[string]string{"github.com/depbleed/go": "GO", "github.com/depbleed/py": "PYTHON"} {
+		if _, err := b.persistence.AppendAnalysis(context.Background(), url, language, &persistence.Analysis{Hash: "def"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	newMux(b).ServeHTTP(w, httptest.NewRequest("GET", "/leaks/go/all/0/10", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "depbleed/go") || strings.Contains(w.Body.String(), "depbleed/py") {
+		t.Errorf("expected only the repositories analyzed in go; got %d %s", w.Code, w.Body.String())
+	}
+
+	//The analyses of the repositories of the github user all share the route
+	if _, err := b.persistence.AppendAnalysis(context.Background(), "github.com/all/repo", "GO", &persistence.Analysis{Hash: "def"}); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		path   string
+		status int
+	}{
+		{"/leaks/go/all/repo/def", http.StatusOK},
+		{"/leaks/go/all/repo/abc", http.StatusNotFound},
+		{"/leaks/go/all/other/def", http.StatusBadRequest},
+	}
+	for _, testCase := range testCases {
+		w := httptest.NewRecorder()
+		newMux(b).ServeHTTP(w, httptest.NewRequest("GET", testCase.path, nil))
+		if w.Code != testCase.status {
+			t.Errorf("%s: expected status %d; got %d %s", testCase.path, testCase.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+
+	mux := newMux(newBackend())
+
+	r := httptest.NewRequest("GET", "/leaks/go/all/a/10", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	if w.Header().Get("X-Request-ID") != "abc-123" {
+		t.Errorf("expected the request ID abc-123; got %s", w.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestBadge(t *testing.T) {
+
+	mux := goji.NewMux()
+	mux.HandleFunc(pat.Get("/badge/:language/:user/:repo.svg"), badge(newBackend()))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/badge/go/depbleed/go.svg", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != render.BadgeContentType {
+		t.Errorf("expected an SVG; got %s", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "leaks: unknown") {
+		t.Errorf("expected an unknown badge; got %s", w.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/badge/go/depbleed/go.svg", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status 304; got %d", w.Code)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+
+	var out bytes.Buffer
+	handler := withRequestID(withAccessLog(logger.New(&out, logger.LevelInfo, logger.FormatJSON))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})))
+
+	r := httptest.NewRequest("GET", "/leaks/go/all/0/10", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line; got %s", out.String())
+	}
+
+	expected := map[string]interface{}{
+		"msg":        "request",
+		"method":     "GET",
+		"path":       "/leaks/go/all/0/10",
+		"status":     float64(200),
+		"bytes":      float64(5),
+		"request_id": "abc-123",
+		"ip":         "192.0.2.1",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("expected %s=%v; got %v", key, value, line[key])
+		}
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+
+	testCases := []struct {
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		{"10.0.0.1", nil, "10.0.0.1"},
+		{"10.0.0.1:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"10.0.0.1:1234", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"10.0.0.1:1234", []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expected, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = testCase.remoteAddr
+			for _, forwarded := range testCase.forwarded {
+				r.Header.Add("X-Forwarded-For", forwarded)
+			}
+
+			if ip := remoteIP(r); ip != testCase.expected {
+				t.Errorf("expected %s; got %s", testCase.expected, ip)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+
+	mux := newMux(newBackend())
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/badge/go/depbleed/go.svg", nil))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, expected := range []string{
+		`depbleed_http_requests_total{code="200",method="GET",route="/badge/:language/:user/:repo.svg"}`,
+		`depbleed_http_request_duration_seconds_count{route="/badge/:language/:user/:repo.svg"}`,
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("expected %s in\n%s", expected, w.Body.String())
+		}
+	}
+
+	store := persistence.NewMemory()
+	for _, commit := range []string{"a", "b", "c"} {
+		store.EnqueueJob(context.Background(), "depbleed/go", "GO", commit)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(queueDepth(store))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetMetric()[0].GetGauge().GetValue() != 3 {
+		t.Errorf("expected 3 jobs queued; got %v", families)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+
+	testCases := []struct {
+		name   string
+		checks []check
+		status int
+		body   readiness
+	}{
+		{
+			"ready",
+			[]check{
+				{name: "mongo", run: func(ctx context.Context) error { return nil }},
+				diskCheck(os.TempDir()),
+			},
+			http.StatusOK,
+			readiness{Status: "ok", Checks: map[string]checkResult{
+				"mongo": {Status: "ok"},
+				"disk":  {Status: "ok"},
+			}},
+		},
+		{
+			"failing",
+			[]check{
+				{name: "mongo", run: func(ctx context.Context) error { return errors.New("no reachable servers") }},
+				commandCheck("depbleed-missing-command"),
+			},
+			http.StatusServiceUnavailable,
+			readiness{Status: "unavailable", Checks: map[string]checkResult{
+				"mongo":                    {Status: "failing", Error: "no reachable servers"},
+				"depbleed-missing-command": {Status: "failing"},
+			}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			mux := newMux(&backend{checks: testCase.checks})
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+			if w.Code != testCase.status {
+				t.Errorf("expected status %d; got %d", testCase.status, w.Code)
+			}
+
+			var body readiness
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected a JSON body; got %s", w.Body.String())
+			}
+			if body.Status != testCase.body.Status {
+				t.Errorf("expected status %s; got %s", testCase.body.Status, body.Status)
+			}
+			for name, expected := range testCase.body.Checks {
+				result := body.Checks[name]
+				if result.Status != expected.Status || (expected.Error != "" && result.Error != expected.Error) {
+					t.Errorf("expected %s to be %+v; got %+v", name, expected, result)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	newMux(&backend{}).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200; got %d", w.Code)
+	}
+}
+
+func TestAllowedHosts(t *testing.T) {
+
+	mux := newMux(&backend{allowedHosts: []string{"depbleed.io"}})
+
+	testCases := []struct {
+		host   string
+		status int
+	}{
+		{"depbleed.io", http.StatusOK},
+		{"DEPBLEED.io:443", http.StatusOK},
+		{"evil.example", http.StatusMisdirectedRequest},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.host, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/healthz", nil)
+			r.Host = testCase.host
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
 
-	log("a", "b", "c", "d", "e", "f")
+			if w.Code != testCase.status {
+				t.Errorf("expected status %d; got %d", testCase.status, w.Code)
+			}
+		})
+	}
 }
 
-type mockDAO struct{}
+func TestCORS(t *testing.T) {
+
+	mux := newMux(&backend{corsOrigins: []string{"https://depbleed.io"}})
+
+	testCases := []struct {
+		name    string
+		method  string
+		origin  string
+		status  int
+		allowed string
+	}{
+		{"allowed", "GET", "https://depbleed.io", http.StatusOK, "https://depbleed.io"},
+		{"other origin", "GET", "https://evil.example", http.StatusOK, ""},
+		{"preflight", "OPTIONS", "https://depbleed.io", http.StatusNoContent, "https://depbleed.io"},
+	}
 
-func (mg *mockDAO) UpdateRepo(repository persistence.Repository) error {
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := httptest.NewRequest(testCase.method, "/healthz", nil)
+			r.Header.Set("Origin", testCase.origin)
+			if testCase.method == "OPTIONS" {
+				r.Header.Set("Access-Control-Request-Method", "GET")
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
 
-	if repository.URL == "" {
-		return errors.New("bla")
+			if w.Code != testCase.status {
+				t.Errorf("expected status %d; got %d", testCase.status, w.Code)
+			}
+			if allowed := w.Header().Get("Access-Control-Allow-Origin"); allowed != testCase.allowed {
+				t.Errorf("expected allowed origin %q; got %q", testCase.allowed, allowed)
+			}
+		})
 	}
-	return nil
 }
-func (mg *mockDAO) InsertRepo(repository persistence.Repository) error {
-	if repository.URL == "" {
-		return errors.New("bla")
+
+func TestConfigCommand(t *testing.T) {
+
+	os.Setenv("GITHUB_TOKEN", "ghp_secret")
+	defer os.Unsetenv("GITHUB_TOKEN")
+
+	var stdout, stderr bytes.Buffer
+	code := configCommand([]string{"print", "-mongo-url", "localhost:27017", "-mongo-db", "depbleed"}, &stdout, &stderr)
+
+	if code != exitOK {
+		t.Fatalf("expected exit code %d; got %d: %s", exitOK, code, stderr.String())
+	}
+	if strings.Contains(stdout.String(), "ghp_secret") || !strings.Contains(stdout.String(), "REDACTED") {
+		t.Errorf("expected the token to be redacted; got %s", stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := configCommand([]string{"print", "-workers", "0"}, &stdout, &stderr); code != exitError {
+		t.Errorf("expected exit code %d; got %d", exitError, code)
+	}
+	if !strings.Contains(stderr.String(), "workers must be at least 1") {
+		t.Errorf("expected a validation error; got %s", stderr.String())
 	}
-	return nil
 }
-func (mg *mockDAO) FindRepo(url string) (persistence.Repository, error) {
-	if url == "" {
-		return persistence.Repository{}, errors.New("bla")
+
+func TestEndToEnd(t *testing.T) {
+
+	store := persistence.NewMemory()
+	mux := newMux(&backend{
+		persistence: store,
+		queue:       store,
+		languages:   []string{"GO"},
+		lastCommit: func(ctx context.Context, repo string) (string, error) {
+			return "abc", nil
+		},
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/leaks/go/depbleed/go"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected the analysis to be queued; got %d", w.Code)
+	}
+	//The formats only showing analyses answer the queued state, not an error
+	if w := get("/leaks/go/depbleed/go?format=csv"); w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"status": "queued"`) {
+		t.Errorf("expected the queued state; got %d %s", w.Code, w.Body.String())
+	}
+
+	w := &worker.Worker{
+		ID:          "test",
+		Persistence: store,
+		Queue:       store,
+		Analyse: func(ctx context.Context, repo string, language string, commit string) (string, *persistence.Analysis, error) {
+			return language, &persistence.Analysis{
+				Hash:   commit,
+				Status: persistence.StatusDone,
+				Leaks:  []*persistence.Leak{{File: "go.go", Line: 12, Kind: persistence.LeakVendored}},
+				Score:  0.5,
+				Grade:  "C",
+			}, nil
+		},
+		DrainTimeout: time.Second,
+	}
+	stopping, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Serve(stopping)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, _ := store.CountJobs(context.Background(), persistence.JobDone); n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the worker to run the job")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	<-done
+
+	testCases := []struct {
+		path     string
+		status   int
+		expected string
+	}{
+		{"/leaks/go/depbleed/go", http.StatusOK, `"hash": "abc"`},
+		{"/leaks/go/depbleed/go/abc", http.StatusOK, `"file": "go.go"`},
+		{"/leaks/go/all/0/10", http.StatusOK, `"url": "github.com/depbleed/go"`},
+		{"/badge/go/depbleed/go.svg", http.StatusOK, "leaks: 1"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			w := get(testCase.path)
+
+			if w.Code != testCase.status {
+				t.Errorf("expected status %d; got %d", testCase.status, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), testCase.expected) {
+				t.Errorf("expected %s in %s", testCase.expected, w.Body.String())
+			}
+		})
 	}
-	return persistence.Repository{}, nil
 }
 
-func (mg *mockDAO) FindAll(skip int, limit int) ([]persistence.Repository, error) {
+//failingStore fails the reads of the repositories with err, or once the
+//context is done when err is nil
+type failingStore struct {
+	persistence.Store
+	err error
+}
 
-	if skip == -1 {
-		return nil, errors.New("bla")
+func (f *failingStore) FindRepo(ctx context.Context, url string) (persistence.Repository, error) {
+	if f.err != nil {
+		return persistence.Repository{}, f.err
 	}
+	<-ctx.Done()
+	return persistence.Repository{}, ctx.Err()
+}
+
+func (f *failingStore) FindAll(ctx context.Context, language string, skip int, limit int, sort string) ([]persistence.Repository, error) {
+	_, err := f.FindRepo(ctx, "")
+	return nil, err
+}
+
+func TestStoreErrors(t *testing.T) {
+
+	testCases := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"unavailable", io.EOF, http.StatusServiceUnavailable, codeStoreUnavailable},
+		{"timeout", nil, http.StatusGatewayTimeout, codeTimeout},
+		{"failing", errors.New("invalid document"), http.StatusInternalServerError, codeInternal},
+	}
+
+	for _, testCase := range testCases {
+		for _, path := range []string{"/leaks/go/depbleed/go", "/leaks/go/depbleed/go/abc", "/leaks/go/all/0/10", "/badge/go/depbleed/go.svg"} {
+			t.Run(testCase.name+path, func(t *testing.T) {
+				store := &failingStore{Store: persistence.NewMemory(), err: testCase.err}
+				mux := newMux(&backend{
+					persistence: store,
+					queue:       store,
+					languages:   []string{"GO"},
+					lastCommit: func(ctx context.Context, repo string) (string, error) {
+						return "abc", nil
+					},
+					storeTimeout: 10 * time.Millisecond,
+				})
+
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+				if w.Code != testCase.status {
+					t.Errorf("expected status %d; got %d", testCase.status, w.Code)
+				}
+				var body apiError
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("expected a JSON body; got %s", w.Body.String())
+				}
+				if body.Code != testCase.code {
+					t.Errorf("expected code %s; got %s", testCase.code, body.Code)
+				}
+			})
+		}
+	}
+}
+
+func TestGithubTimeout(t *testing.T) {
+
+	store := persistence.NewMemory()
+	mux := newMux(&backend{
+		persistence: store,
+		queue:       store,
+		languages:   []string{"GO"},
+		lastCommit: func(ctx context.Context, repo string) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+		githubTimeout: 10 * time.Millisecond,
+	})
 
-	return []persistence.Repository{}, nil
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/leaks/go/depbleed/go", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504; got %d", w.Code)
+	}
+	if n, _ := store.CountJobs(context.Background(), persistence.JobQueued); n != 0 {
+		t.Errorf("expected no analysis to be queued; got %d", n)
+	}
+}
+
+func TestTouch(t *testing.T) {
+
+	b := newBackend()
+	if _, err := b.persistence.AppendAnalysis(context.Background(), "github.com/depbleed/go", "GO", &persistence.Analysis{Hash: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	mux := newMux(b)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/badge/go/depbleed/go.svg", nil))
+
+	repository, err := b.persistence.FindRepo(context.Background(), "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(time.Unix(repository.Requested, 0)) > time.Minute {
+		t.Errorf("expected the request to be recorded; got %d", repository.Requested)
+	}
+}
+
+func TestPruneCommand(t *testing.T) {
+
+	var stdout, stderr bytes.Buffer
+	if code := pruneCommand([]string{"-storage", "memory", "-dry-run"}, &stdout, &stderr); code != exitError {
+		t.Errorf("expected exit code %d without retention; got %d", exitError, code)
+	}
+
+	store := persistence.NewMemory()
+	old := time.Now().AddDate(-2, 0, 0).Unix()
+	if _, err := store.AppendAnalysis(context.Background(), "github.com/depbleed/old", "GO", &persistence.Analysis{Hash: "abc", Time: old}); err != nil {
+		t.Fatal(err)
+	}
+	retention := persistence.Retention{Unrequested: 365 * 24 * time.Hour}
+
+	testCases := []struct {
+		dryRun   bool
+		expected string
+	}{
+		{true, "would delete github.com/depbleed/old\nwould delete 1 repositories and 0 analyses\n"},
+		{false, "deleted github.com/depbleed/old\ndeleted 1 repositories and 0 analyses\n"},
+		{false, "deleted 0 repositories and 0 analyses\n"},
+	}
+
+	for _, testCase := range testCases {
+		stdout.Reset()
+		if code := prune(store, retention, testCase.dryRun, &stdout, &stderr); code != exitOK {
+			t.Fatalf("expected exit code %d; got %d: %s", exitOK, code, stderr.String())
+		}
+		if stdout.String() != testCase.expected {
+			t.Errorf("expected %q; got %q", testCase.expected, stdout.String())
+		}
+	}
+}
+
+func TestExportImportCommands(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "depbleed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := []string{"-storage", "bolt", "-bolt-path", filepath.Join(dir, "depbleed.db")}
+
+	records := `{"url": "github.com/depbleed/go", "language": "GO", "analysis": {"hash": "a", "timestamp": 1514764800}}
+{"url": "github.com/depbleed/go", "language": "GO", "analysis": {"hash": "b", "timestamp": 1517443200}}
+{"url": "github.com/other/go", "language": "GO", "analysis": {"hash": "c", "timestamp": 1517443200}}
+`
+	var stdout, stderr bytes.Buffer
+	//The analyses imported again are already stored
+	for _, expected := range []string{"imported 3 analyses\n", "imported 0 analyses\n"} {
+		stdout.Reset()
+		if code := importCommand(storage, strings.NewReader(records), &stdout, &stderr); code != exitOK {
+			t.Fatalf("expected exit code %d; got %d: %s", exitOK, code, stderr.String())
+		}
+		if stdout.String() != expected {
+			t.Errorf("expected %s; got %s", expected, stdout.String())
+		}
+	}
+
+	testCases := []struct {
+		args     []string
+		expected []string
+	}{
+		{nil, []string{"a", "b", "c"}},
+		{[]string{"-prefix", "github.com/depbleed/"}, []string{"a", "b"}},
+		{[]string{"-since", "2018-02-01"}, []string{"b", "c"}},
+		{[]string{"-until", "2018-02-01T00:00:00Z"}, []string{"a"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(strings.Join(testCase.args, " "), func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := exportCommand(append(testCase.args, storage...), &stdout, &stderr); code != exitOK {
+				t.Fatalf("expected exit code %d; got %d: %s", exitOK, code, stderr.String())
+			}
+
+			hashes := []string{}
+			decoder := json.NewDecoder(&stdout)
+			for decoder.More() {
+				var record persistence.Record
+				if err := decoder.Decode(&record); err != nil {
+					t.Fatal(err)
+				}
+				hashes = append(hashes, record.Analysis.Hash)
+			}
+			if strings.Join(hashes, " ") != strings.Join(testCase.expected, " ") {
+				t.Errorf("expected the analyses %v; got %v", testCase.expected, hashes)
+			}
+		})
+	}
+
+	if code := exportCommand(append([]string{"-since", "yesterday"}, storage...), &stdout, &stderr); code != exitError {
+		t.Errorf("expected exit code %d for an invalid date; got %d", exitError, code)
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "depbleed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-storage", "memory"}, "the memory store is at schema version"},
+		{[]string{"-storage", "bolt", "-bolt-path", filepath.Join(dir, "depbleed.db")}, "migrated 0 repositories to schema version"},
+	}
+
+	for _, testCase := range testCases {
+		var stdout, stderr bytes.Buffer
+		if code := migrateCommand(testCase.args, &stdout, &stderr); code != exitOK {
+			t.Fatalf("expected exit code %d; got %d: %s", exitOK, code, stderr.String())
+		}
+		if !strings.HasPrefix(stdout.String(), testCase.expected) {
+			t.Errorf("expected %q; got %q", testCase.expected, stdout.String())
+		}
+	}
 }
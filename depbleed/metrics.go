@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/depbleed/backend/persistence"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	//requests counts the requests served by route
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "depbleed_http_requests_total",
+		Help: "HTTP requests served.",
+	}, []string{"route", "method", "code"})
+	//requestDuration is the latency of the requests by route
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "depbleed_http_request_duration_seconds",
+		Help: "Latency of the HTTP requests.",
+	}, []string{"route"})
+	//analysisRequests counts the analysis requests answered from the stored
+	//analysis (up_to_date) or queued (queued), for the cache hit ratio
+	analysisRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "depbleed_analysis_requests_total",
+		Help: "Analysis requests, by whether the stored analysis was up to date.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(requests, requestDuration, analysisRequests)
+}
+
+//instrument counts the requests served by handler and their latency under
+//route
+func instrument(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		handler(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		requests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	}
+}
+
+//jobsQueued is the description of the queue depth
+var jobsQueued = prometheus.NewDesc(
+	"depbleed_jobs_queued",
+	"Analysis jobs waiting for a worker.",
+	nil, nil,
+)
+
+//queueCollector exposes the number of jobs queued. No sample is exposed when
+//the queue can't be counted.
+type queueCollector struct {
+	queue persistence.Queue
+}
+
+//queueDepth exposes the number of queued jobs
+func queueDepth(queue persistence.Queue) prometheus.Collector {
+	return queueCollector{queue: queue}
+}
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobsQueued
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	count, err := c.queue.CountJobs(ctx, persistence.JobQueued)
+	if err != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(jobsQueued, prometheus.GaugeValue, float64(count))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//migrateCommand implements `depbleed migrate`. It upgrades the stored
+//repositories to the current schema version at once rather than on read.
+func migrateCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: depbleed migrate [flags]")
+		fmt.Fprintln(stderr, "\nUpgrades the repositories stored with an older schema version. They are")
+		fmt.Fprintln(stderr, "upgraded when read otherwise.")
+		flags.PrintDefaults()
+	}
+
+	cfg, store, code := openStore(flags, args, stderr)
+	if store == nil {
+		return code
+	}
+	defer store.Close()
+
+	return migrate(store, cfg.Storage, stdout, stderr)
+}
+
+//migrate upgrades the documents of store and reports it to stdout
+func migrate(store persistence.Store, storage string, stdout io.Writer, stderr io.Writer) int {
+	migrator, ok := store.(persistence.Migrator)
+	if !ok {
+		//The tables are migrated when the store opens
+		fmt.Fprintf(stdout, "the %s store is at schema version %d\n", storage, persistence.SchemaVersion)
+		return exitOK
+	}
+
+	count, err := migrator.MigrateDocuments(context.Background())
+	fmt.Fprintf(stdout, "migrated %d repositories to schema version %d\n", count, persistence.SchemaVersion)
+	if err != nil {
+		fmt.Fprintln(stderr, "Can't migrate:", err.Error())
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//pruneCommand implements `depbleed prune`. It deletes the analyses and the
+//repositories the configured retention doesn't keep and reports them.
+func pruneCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dryRun := flags.Bool("dry-run", false, "report what would be deleted without deleting it")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: depbleed prune [-dry-run] [flags]")
+		fmt.Fprintln(stderr, "\nDeletes the analyses and the repositories the retention doesn't keep:")
+		fmt.Fprintln(stderr, "the analyses beyond the latest -retention-analyses ones and the latest")
+		fmt.Fprintln(stderr, "one of each month, and the repositories nobody requested for")
+		fmt.Fprintln(stderr, "-retention-unrequested.")
+		flags.PrintDefaults()
+	}
+
+	cfg, store, code := openStore(flags, args, stderr)
+	if store == nil {
+		return code
+	}
+	defer store.Close()
+
+	return prune(store, cfg.RetentionPolicy(), *dryRun, stdout, stderr)
+}
+
+//prune applies retention to dao and reports the deletions to stdout
+func prune(dao persistence.DAO, retention persistence.Retention, dryRun bool, stdout io.Writer, stderr io.Writer) int {
+	if retention.Analyses == 0 && retention.Unrequested == 0 {
+		fmt.Fprintln(stderr, "Nothing to prune without -retention-analyses nor -retention-unrequested")
+		return exitError
+	}
+
+	prunings, err := persistence.Prune(context.Background(), dao, retention, time.Now(), dryRun)
+
+	verb := "deleted"
+	if dryRun {
+		verb = "would delete"
+	}
+	repositories, analyses := 0, 0
+	for _, pruning := range prunings {
+		if pruning.Repository {
+			repositories++
+			fmt.Fprintf(stdout, "%s %s\n", verb, pruning.URL)
+			continue
+		}
+		analyses += len(pruning.Hashes)
+		fmt.Fprintf(stdout, "%s %d analyses of %s: %s\n", verb, len(pruning.Hashes), pruning.URL, strings.Join(pruning.Hashes, " "))
+	}
+	fmt.Fprintf(stdout, "%s %d repositories and %d analyses\n", verb, repositories, analyses)
+
+	if err != nil {
+		fmt.Fprintln(stderr, "Can't prune:", err.Error())
+		return exitError
+	}
+	return exitOK
+}
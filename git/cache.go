@@ -0,0 +1,320 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//mirrorsDir is the directory, relative to the cache root, holding the bare
+//mirrors. It starts with a dot so the go tool ignores it.
+const mirrorsDir = ".mirrors"
+
+//remoteURL returns the URL a repo is mirrored from
+var remoteURL = func(repo string) string {
+	return "https://github.com/" + repo
+}
+
+//Cache keeps bare mirrors of the analyzed repositories on disk so that
+//subsequent analyses only fetch the new objects
+type Cache struct {
+	dir     string
+	maxSize int64
+	limits  Limits
+
+	mu      sync.Mutex
+	mirrors map[string]*mirror
+}
+
+//mirror is a bare mirror of one repository
+type mirror struct {
+	lock     sync.Mutex
+	path     string
+	size     int64
+	lastUsed time.Time
+	inUse    int
+}
+
+//NewCache returns a cache storing its mirrors under dir. Once the mirrors
+//take more than maxSize bytes, the least recently used ones are evicted.
+//A maxSize of 0 disables the eviction. The checked out repositories are
+//refused once they exceed limits.
+func NewCache(dir string, maxSize int64, limits Limits) (*Cache, error) {
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		limits:  limits,
+		mirrors: map[string]*mirror{},
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, mirrorsDir), 0755); err != nil {
+		return nil, err
+	}
+
+	//Pick up the mirrors left by a previous run
+	paths, err := filepath.Glob(filepath.Join(dir, mirrorsDir, "*", "*.git"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		rel, _ := filepath.Rel(filepath.Join(dir, mirrorsDir), path)
+		repo := strings.TrimSuffix(filepath.ToSlash(rel), ".git")
+		c.mirrors[repo] = &mirror{
+			path:     path,
+			size:     diskUsage(path),
+			lastUsed: info.ModTime(),
+		}
+	}
+
+	return c, nil
+}
+
+//Checkout makes commit of repo available in a worktree and returns its path.
+//The mirror is cloned on first use and fetched afterwards. The mirror stays
+//locked until release is called, which also removes the worktree.
+//A *RefusedError is returned when the repository exceeds the limits.
+func (c *Cache) Checkout(ctx context.Context, repo string, commit string) (path string, release func(), err error) {
+	m := c.acquire(repo)
+	m.lock.Lock()
+
+	defer func() {
+		if err != nil {
+			if _, refused := err.(*RefusedError); refused {
+				os.RemoveAll(m.path)
+			}
+			c.release(repo, m)
+		}
+	}()
+
+	if err = c.update(ctx, repo, m); err != nil {
+		return "", nil, err
+	}
+
+	if commit == "" {
+		commit = "HEAD"
+	}
+
+	path = c.worktree(repo)
+	os.RemoveAll(path)
+	if err = run(ctx, "--git-dir="+m.path, "worktree", "prune"); err != nil {
+		return "", nil, err
+	}
+	if err = run(ctx, "--git-dir="+m.path, "worktree", "add", "--detach", path, commit); err != nil {
+		return "", nil, err
+	}
+
+	remove := func() {
+		os.RemoveAll(path)
+		run(context.Background(), "--git-dir="+m.path, "worktree", "prune")
+	}
+
+	if err = c.limits.checkWorktree(path); err != nil {
+		remove()
+		return "", nil, err
+	}
+
+	return path, func() {
+		remove()
+		c.release(repo, m)
+	}, nil
+}
+
+//update clones or fetches the mirror within the limits
+func (c *Cache) update(parent context.Context, repo string, m *mirror) error {
+	_, statErr := os.Stat(m.path)
+	cloned := !os.IsNotExist(statErr)
+
+	if !cloned {
+		if err := c.limits.checkMetadata(parent, repo); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := parent, func() {}
+	if c.limits.CloneTimeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, c.limits.CloneTimeout)
+	}
+	defer cancel()
+
+	guard := c.limits.watch(m.path, cancel)
+
+	var err error
+	if cloned {
+		err = run(ctx, "--git-dir="+m.path, "fetch", "--prune", "origin")
+	} else if err = os.MkdirAll(filepath.Dir(m.path), 0755); err == nil {
+		err = run(ctx, "clone", "--mirror", remoteURL(repo), m.path)
+		if err != nil {
+			os.RemoveAll(m.path)
+		}
+	}
+
+	guard.stop()
+	if err != nil {
+		return c.limits.explain(err, parent, ctx, guard)
+	}
+	return nil
+}
+
+//Clean removes the checkouts left by an interrupted run, except the ones of
+//the mirrors in use, and prunes their worktrees from the mirrors
+func (c *Cache) Clean() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	users, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if !user.IsDir() || user.Name() == mirrorsDir {
+			continue
+		}
+		repos, err := ioutil.ReadDir(filepath.Join(c.dir, user.Name()))
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			if m, ok := c.mirrors[user.Name()+"/"+repo.Name()]; ok && m.inUse > 0 {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(c.dir, user.Name(), repo.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, m := range c.mirrors {
+		if m.inUse > 0 {
+			continue
+		}
+		if _, err := os.Stat(m.path); err == nil {
+			run(context.Background(), "--git-dir="+m.path, "worktree", "prune")
+		}
+	}
+	return nil
+}
+
+//Dir returns the directory the repositories are checked out in
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+//worktree returns the directory a repo is checked out in
+func (c *Cache) worktree(repo string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(repo))
+}
+
+//acquire returns the mirror of repo and marks it as being used
+func (c *Cache) acquire(repo string) *mirror {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, ok := c.mirrors[repo]
+	if !ok {
+		m = &mirror{
+			path: filepath.Join(c.dir, mirrorsDir, filepath.FromSlash(repo)+".git"),
+		}
+		c.mirrors[repo] = m
+	}
+	m.inUse++
+	return m
+}
+
+//release unlocks the mirror of repo, records its new size and evicts the
+//least recently used mirrors if needed
+func (c *Cache) release(repo string, m *mirror) {
+	size := diskUsage(m.path)
+	m.lock.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m.inUse--
+	m.size = size
+	m.lastUsed = time.Now()
+	if size == 0 && m.inUse == 0 {
+		//The clone failed; forget about it
+		delete(c.mirrors, repo)
+	}
+	c.evict()
+}
+
+//evict removes the least recently used mirrors until the cache fits in
+//maxSize. Mirrors in use are never evicted. c.mu must be held.
+func (c *Cache) evict() {
+	if c.maxSize <= 0 {
+		return
+	}
+
+	var total int64
+	repos := []string{}
+	for repo, m := range c.mirrors {
+		total += m.size
+		repos = append(repos, repo)
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		return c.mirrors[repos[i]].lastUsed.Before(c.mirrors[repos[j]].lastUsed)
+	})
+
+	for _, repo := range repos {
+		if total <= c.maxSize {
+			return
+		}
+		m := c.mirrors[repo]
+		if m.inUse > 0 {
+			continue
+		}
+		os.RemoveAll(m.path)
+		total -= m.size
+		delete(c.mirrors, repo)
+	}
+}
+
+//Size returns the disk space used by the mirrors
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total int64
+	for _, m := range c.mirrors {
+		total += m.size
+	}
+	return total
+}
+
+//diskUsage returns the size of the files under path
+func diskUsage(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+//run runs a git command and returns its output as error on failure
+func run(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	//Never wait for credentials on a private or missing repo and never
+	//download LFS objects
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_LFS_SKIP_SMUDGE=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
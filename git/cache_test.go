@@ -0,0 +1,283 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//newOrigin creates a local repository to mirror from and returns its path
+func newOrigin(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, dir, "init", "-q")
+	return dir
+}
+
+//commitFile commits a file in the origin and returns the commit hash
+func commitFile(t *testing.T, origin string, name string) string {
+	if err := ioutil.WriteFile(filepath.Join(origin, name), []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, origin, "add", name)
+	gitIn(t, origin, "-c", "user.name=test", "-c", "user.email=test@depbleed.io", "commit", "-q", "-m", name)
+	return gitIn(t, origin, "rev-parse", "HEAD")
+}
+
+func gitIn(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func withOrigin(origin string) func() {
+	previous := remoteURL
+	remoteURL = func(repo string) string {
+		return origin
+	}
+	return func() {
+		remoteURL = previous
+	}
+}
+
+func TestCacheCheckout(t *testing.T) {
+	origin := newOrigin(t)
+	defer os.RemoveAll(origin)
+	defer withOrigin(origin)()
+
+	dir, _ := ioutil.TempDir("", "cache")
+	defer os.RemoveAll(dir)
+
+	cache, err := NewCache(dir, 0, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := commitFile(t, origin, "a.go")
+
+	path, release, err := cache.Checkout(context.Background(), "depbleed/go", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "depbleed", "go") {
+		t.Errorf("expected worktree in %s; got %s", filepath.Join(dir, "depbleed", "go"), path)
+	}
+	if _, err := os.Stat(filepath.Join(path, "a.go")); err != nil {
+		t.Errorf("expected a.go to be checked out %s", err.Error())
+	}
+	release()
+
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("expected worktree to be deleted")
+	}
+
+	//The second checkout fetches the new commit in the existing mirror
+	second := commitFile(t, origin, "b.go")
+
+	path, release, err = cache.Checkout(context.Background(), "depbleed/go", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "b.go")); err != nil {
+		t.Errorf("expected b.go to be checked out %s", err.Error())
+	}
+	release()
+
+	//Mirrors are picked up again after a restart
+	cache, err = NewCache(dir, 0, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Size() == 0 {
+		t.Errorf("expected the existing mirror to be reused")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	origin := newOrigin(t)
+	defer os.RemoveAll(origin)
+	defer withOrigin(origin)()
+
+	dir, _ := ioutil.TempDir("", "cache")
+	defer os.RemoveAll(dir)
+
+	cache, err := NewCache(dir, 1, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := commitFile(t, origin, "a.go")
+
+	_, release, err := cache.Checkout(context.Background(), "depbleed/go", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, releaseOther, err := cache.Checkout(context.Background(), "depbleed/backend", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//depbleed/go is still in use and can't be evicted yet
+	releaseOther()
+	if _, err := os.Stat(filepath.Join(dir, mirrorsDir, "depbleed", "go.git")); err != nil {
+		t.Errorf("expected mirror in use to be kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, mirrorsDir, "depbleed", "backend.git")); err == nil {
+		t.Errorf("expected least recently used mirror to be evicted")
+	}
+
+	release()
+	if cache.Size() != 0 {
+		t.Errorf("expected all mirrors to be evicted; got %d bytes", cache.Size())
+	}
+}
+
+func TestCacheCheckoutUnknownRepo(t *testing.T) {
+	defer withOrigin("/does/not/exist")()
+
+	dir, _ := ioutil.TempDir("", "cache")
+	defer os.RemoveAll(dir)
+
+	cache, _ := NewCache(dir, 0, Limits{})
+
+	if _, _, err := cache.Checkout(context.Background(), "depbleed/none", ""); err == nil {
+		t.Errorf("expected an error for an unknown repo")
+	}
+	if cache.Size() != 0 {
+		t.Errorf("expected failed clone to be forgotten")
+	}
+}
+
+func TestCacheLimits(t *testing.T) {
+	origin := newOrigin(t)
+	defer os.RemoveAll(origin)
+	defer withOrigin(origin)()
+
+	commitFile(t, origin, "a.go")
+	commitFile(t, origin, "b.go")
+	commitFile(t, origin, ".gitmodules")
+
+	previous := repoSize
+	defer func() { repoSize = previous }()
+	repoSize = func(ctx context.Context, repo string) (int64, error) {
+		return 2 << 20, nil
+	}
+
+	testCases := []struct {
+		Name   string
+		Limits Limits
+		Reason string
+	}{
+		{
+			Name:   "none",
+			Limits: Limits{},
+		},
+		{
+			Name:   "timeout",
+			Limits: Limits{CloneTimeout: time.Nanosecond},
+			Reason: "cloning took more than 1ns",
+		},
+		{
+			Name:   "metadata size",
+			Limits: Limits{MaxRepoSize: 1 << 20},
+			Reason: "repository size 2097152 bytes exceeds the 1048576 bytes limit",
+		},
+		{
+			Name:   "go files",
+			Limits: Limits{MaxGoFiles: 1},
+			Reason: "repository has 2 Go files, more than the 1 files limit",
+		},
+		{
+			Name:   "submodules",
+			Limits: Limits{Submodules: Refuse},
+			Reason: "repository uses git submodules",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "cache")
+			defer os.RemoveAll(dir)
+
+			cache, _ := NewCache(dir, 0, testCase.Limits)
+			_, release, err := cache.Checkout(context.Background(), "depbleed/go", "")
+
+			if testCase.Reason == "" {
+				if err != nil {
+					t.Fatalf("expected no error; got %s", err)
+				}
+				release()
+				return
+			}
+
+			refused, ok := err.(*RefusedError)
+			if !ok {
+				t.Fatalf("expected a RefusedError; got %v", err)
+			}
+			if refused.Reason != testCase.Reason {
+				t.Errorf("expected reason %q; got %q", testCase.Reason, refused.Reason)
+			}
+			if cache.Size() != 0 {
+				t.Errorf("expected refused mirror to be removed")
+			}
+		})
+	}
+}
+
+func TestCacheClean(t *testing.T) {
+	origin := newOrigin(t)
+	defer os.RemoveAll(origin)
+	defer withOrigin(origin)()
+
+	dir, _ := ioutil.TempDir("", "cache")
+	defer os.RemoveAll(dir)
+
+	cache, err := NewCache(dir, 0, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := commitFile(t, origin, "a.go")
+
+	//A checkout of a previous run which was never released
+	path, _, err := cache.Checkout(context.Background(), "depbleed/go", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err = NewCache(dir, 0, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used, release, err := cache.Checkout(context.Background(), "depbleed/other", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if err := cache.Clean(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("expected the abandoned worktree to be deleted")
+	}
+	if _, err := os.Stat(used); err != nil {
+		t.Errorf("expected the worktree in use to be kept %s", err.Error())
+	}
+	if cache.Size() == 0 {
+		t.Errorf("expected the mirrors to be kept")
+	}
+}
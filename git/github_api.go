@@ -1,36 +1,93 @@
 package git
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
-	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-//FetchLastCommit fetches the last commit hash of repo
-func FetchLastCommit(repo string) string {
-	//Query github to get the last commit
-	var netTransport = &http.Transport{
+//netClient is the client used to query the github API
+var netClient = &http.Client{
+	Timeout: time.Second * 10,
+	Transport: &http.Transport{
 		Dial: (&net.Dialer{
 			Timeout: 5 * time.Second,
 		}).Dial,
 		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+//githubToken authenticates the github API calls when it isn't empty
+var githubToken string
+
+//SetGithubToken authenticates the github API calls with token, for a higher
+//rate limit
+func SetGithubToken(token string) {
+	githubToken = token
+}
+
+//githubGet queries the github API until ctx is done
+func githubGet(ctx context.Context, url string) (*http.Response, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
 	}
-	var netClient = &http.Client{
-		Timeout:   time.Second * 10,
-		Transport: netTransport,
+	request = request.WithContext(ctx)
+	request.Header.Set("Accept", "application/vnd.github.v3+json")
+	if githubToken != "" {
+		request.Header.Set("Authorization", "token "+githubToken)
 	}
+	return netClient.Do(request)
+}
+
+//rateLimitRemaining is the number of github API calls left, as reported by
+//the last response
+var rateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "depbleed_github_rate_limit_remaining",
+	Help: "GitHub API calls left in the current rate limit window.",
+})
+
+func init() {
+	prometheus.MustRegister(rateLimitRemaining)
+}
+
+//repoSize returns the size of a repo before cloning it
+var repoSize = FetchRepoSize
 
-	response, _ := netClient.Get("https://api.github.com/repos/" + repo + "/git/refs/heads/master")
+var (
+	//ErrRepoNotFound is returned when github doesn't know a repo
+	ErrRepoNotFound = errors.New("repository not found on github")
+	//ErrRateLimited is returned when the github API rate limit is exceeded
+	ErrRateLimited = errors.New("github API rate limit exceeded")
+)
+
+//FetchLastCommit fetches the last commit hash of repo
+func FetchLastCommit(ctx context.Context, repo string) (string, error) {
+	//Query github to get the last commit
+	response, err := githubGet(ctx, "https://api.github.com/repos/"+repo+"/git/refs/heads/master")
+	if err != nil {
+		return "", err
+	}
 	defer response.Body.Close()
+
+	if err := checkResponse(response, repo); err != nil {
+		return "", err
+	}
+
 	body, err := ioutil.ReadAll(response.Body)
 
 	if err != nil {
-		panic(err.Error())
+		return "", err
 	}
 	type githubAPIResponse struct {
 		Ref    string `json:"ref"`
@@ -46,36 +103,60 @@ func FetchLastCommit(repo string) string {
 
 	err = json.Unmarshal(body, &gAPIResponse)
 	if err != nil {
-		panic(err.Error())
+		return "", err
 	}
-	return gAPIResponse.Object.Sha
+	if gAPIResponse.Object.Sha == "" {
+		return "", fmt.Errorf("github returned no commit for %s", repo)
+	}
+	return gAPIResponse.Object.Sha, nil
 }
 
-//CloneRepo clones the repo locally
-func CloneRepo(repo string) {
-	wd, _ := os.Getwd()
-	//Create the directories for the repo
-	cmdArgs := []string{"-p", wd + "/repositories/" + repo}
-	cmd := exec.Command("mkdir", cmdArgs...)
-	if _, err := cmd.Output(); err != nil {
-		log.Panic("There was an error creating the folders", err)
+//checkResponse turns the github API error statuses into errors
+func checkResponse(response *http.Response, repo string) error {
+	if remaining, err := strconv.Atoi(response.Header.Get("X-RateLimit-Remaining")); err == nil {
+		rateLimitRemaining.Set(float64(remaining))
 	}
 
-	//Clone the repo
-	cmdArgs = []string{"clone", "--depth=1", "https://github.com/" + repo, wd + "/repositories/" + repo}
-	cmd = exec.Command("git", cmdArgs...)
-	if _, err := cmd.Output(); err != nil {
-		log.Panic("There was an error running git clone command: ", err)
+	switch {
+	case response.StatusCode == http.StatusOK:
+		return nil
+	case response.StatusCode == http.StatusNotFound:
+		return ErrRepoNotFound
+	case response.StatusCode == http.StatusForbidden && response.Header.Get("X-RateLimit-Remaining") == "0":
+		return ErrRateLimited
 	}
+	return fmt.Errorf("github answered %s for %s", response.Status, repo)
 }
 
-//DeleteRepo deletes a local repo
-func DeleteRepo(repo string) {
-	wd, _ := os.Getwd()
-	//Delete the directories for the repo
-	cmdArgs := []string{"-rf", wd + "/repositories/" + repo}
-	cmd := exec.Command("rm", cmdArgs...)
-	if _, err := cmd.Output(); err != nil {
-		log.Panic("There was an error running deleting folders: ", err)
+//FetchRepoSize fetches the size in bytes of repo as reported by github
+func FetchRepoSize(ctx context.Context, repo string) (int64, error) {
+	response, err := githubGet(ctx, "https://api.github.com/repos/"+repo)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, repo); err != nil {
+		return 0, err
+	}
+
+	var metadata struct {
+		//Size is in kilobytes
+		Size int64 `json:"size"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&metadata); err != nil {
+		return 0, err
+	}
+	return metadata.Size << 10, nil
+}
+
+//Head returns the commit hash checked out in dir
+func Head(ctx context.Context, dir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimSpace(string(out)), nil
 }
@@ -0,0 +1,172 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Policy tells how a repository feature such as submodules is handled
+type Policy string
+
+const (
+	//Ignore analyzes the repository without the feature
+	Ignore Policy = "ignore"
+	//Refuse refuses to analyze a repository using the feature
+	Refuse Policy = "refuse"
+)
+
+//Limits bounds the resources an analyzed repository may use. Zero values
+//disable the corresponding limit.
+type Limits struct {
+	CloneTimeout time.Duration
+	MaxRepoSize  int64
+	MaxGoFiles   int
+	Submodules   Policy
+	LFS          Policy
+}
+
+//DefaultLimits are the limits applied to the repositories by default
+var DefaultLimits = Limits{
+	CloneTimeout: 5 * time.Minute,
+	MaxRepoSize:  500 << 20,
+	MaxGoFiles:   5000,
+	Submodules:   Ignore,
+	LFS:          Ignore,
+}
+
+//RefusedError is returned when a repository exceeds the limits
+type RefusedError struct {
+	Reason string
+}
+
+func (e *RefusedError) Error() string {
+	return "analysis refused: " + e.Reason
+}
+
+//refuse returns a RefusedError for the formatted reason
+func refuse(format string, args ...interface{}) error {
+	return &RefusedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+//checkMetadata refuses repositories whose size, as reported by github, is
+//above the limit
+func (l Limits) checkMetadata(ctx context.Context, repo string) error {
+	if l.MaxRepoSize <= 0 {
+		return nil
+	}
+	size, err := repoSize(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if size > l.MaxRepoSize {
+		return refuse("repository size %d bytes exceeds the %d bytes limit", size, l.MaxRepoSize)
+	}
+	return nil
+}
+
+//checkWorktree refuses checked out repositories exceeding the limits
+func (l Limits) checkWorktree(path string) error {
+	goFiles := 0
+	var size int64
+	var refused error
+
+	err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		size += info.Size()
+
+		switch {
+		case strings.HasSuffix(file, ".go"):
+			goFiles++
+		case info.Name() == ".gitmodules" && l.Submodules == Refuse:
+			refused = refuse("repository uses git submodules")
+		case info.Name() == ".gitattributes" && l.LFS == Refuse:
+			attributes, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if bytes.Contains(attributes, []byte("filter=lfs")) {
+				refused = refuse("repository uses git LFS")
+			}
+		}
+		return nil
+	})
+
+	switch {
+	case err != nil:
+		return err
+	case refused != nil:
+		return refused
+	case l.MaxGoFiles > 0 && goFiles > l.MaxGoFiles:
+		return refuse("repository has %d Go files, more than the %d files limit", goFiles, l.MaxGoFiles)
+	case l.MaxRepoSize > 0 && size > l.MaxRepoSize:
+		return refuse("checked out repository size %d bytes exceeds the %d bytes limit", size, l.MaxRepoSize)
+	}
+	return nil
+}
+
+//sizeGuard cancels a clone once the mirror grows above the size limit
+type sizeGuard struct {
+	done     chan struct{}
+	wg       sync.WaitGroup
+	exceeded bool
+}
+
+//watch polls the size of path until stop is called and calls cancel when it
+//grows above the limit
+func (l Limits) watch(path string, cancel func()) *sizeGuard {
+	g := &sizeGuard{done: make(chan struct{})}
+	if l.MaxRepoSize <= 0 {
+		return g
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-g.done:
+				return
+			case <-ticker.C:
+				if diskUsage(path) > l.MaxRepoSize {
+					g.exceeded = true
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return g
+}
+
+//stop stops the polling
+func (g *sizeGuard) stop() {
+	close(g.done)
+	g.wg.Wait()
+}
+
+//explain turns the error of a git command cancelled because of the limits
+//into a RefusedError
+func (l Limits) explain(err error, parent context.Context, ctx context.Context, g *sizeGuard) error {
+	switch {
+	case g.exceeded:
+		return refuse("repository grew above the %d bytes limit while cloning", l.MaxRepoSize)
+	case ctx.Err() == context.DeadlineExceeded && parent.Err() == nil:
+		return refuse("cloning took more than %s", l.CloneTimeout)
+	}
+	return err
+}
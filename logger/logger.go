@@ -0,0 +1,246 @@
+//Package logger writes leveled, structured logs as JSON or logfmt
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Level is the severity of a log line
+type Level int
+
+//The levels, from the most verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+//ParseLevel returns the level named name
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+//Format is how the log lines are encoded
+type Format string
+
+//The supported formats
+const (
+	FormatLogfmt Format = "logfmt"
+	FormatJSON   Format = "json"
+)
+
+//ParseFormat returns the format named name
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case FormatLogfmt:
+		return FormatLogfmt, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return FormatLogfmt, fmt.Errorf("unknown log format %q", name)
+}
+
+//Logger writes the lines at or above its level. The loggers derived with
+//With share the output of their parent.
+type Logger struct {
+	out    *output
+	level  Level
+	format Format
+	fields []interface{}
+}
+
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+//now is the clock of the loggers; tests override it
+var now = time.Now
+
+//New returns a logger writing to w
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{
+		out:    &output{w: w},
+		level:  level,
+		format: format,
+	}
+}
+
+//With returns a logger adding the key value pairs kv to each line
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+
+	return &Logger{
+		out:    l.out,
+		level:  l.level,
+		format: l.format,
+		fields: fields,
+	}
+}
+
+//Enabled tells whether the lines of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+//Debug logs msg with the key value pairs kv
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+//Info logs msg with the key value pairs kv
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+//Warn logs msg with the key value pairs kv
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(LevelWarn, msg, kv)
+}
+
+//Error logs msg with the key value pairs kv
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	fields = append(fields, "time", now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "MISSING")
+	}
+
+	var line []byte
+	if l.format == FormatJSON {
+		line = encodeJSON(fields)
+	} else {
+		line = encodeLogfmt(fields)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(line)
+}
+
+//value returns the value logged for v
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		if v == nil {
+			return nil
+		}
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func encodeJSON(fields []interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		b.Write(key)
+		b.WriteByte(':')
+		v, err := json.Marshal(value(fields[i+1]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		b.Write(v)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func encodeLogfmt(fields []interface{}) []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteByte('=')
+
+		v := value(fields[i+1])
+		s := fmt.Sprint(v)
+		if v == nil {
+			s = ""
+		}
+		if strings.ContainsAny(s, " =\"\t\r\n\\") || s == "" {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(s)
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+//std is the logger of the package functions
+var std = New(os.Stdout, LevelInfo, FormatLogfmt)
+
+//Default returns the logger of the package functions
+func Default() *Logger {
+	return std
+}
+
+//SetDefault replaces the logger of the package functions
+func SetDefault(l *Logger) {
+	std = l
+}
+
+//Debug logs msg with the default logger
+func Debug(msg string, kv ...interface{}) {
+	std.log(LevelDebug, msg, kv)
+}
+
+//Info logs msg with the default logger
+func Info(msg string, kv ...interface{}) {
+	std.log(LevelInfo, msg, kv)
+}
+
+//Warn logs msg with the default logger
+func Warn(msg string, kv ...interface{}) {
+	std.log(LevelWarn, msg, kv)
+}
+
+//Error logs msg with the default logger
+func Error(msg string, kv ...interface{}) {
+	std.log(LevelError, msg, kv)
+}
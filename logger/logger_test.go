@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+
+	now = func() time.Time {
+		return time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	}
+	defer func() { now = time.Now }()
+
+	testCases := []struct {
+		format   Format
+		log      func(l *Logger)
+		expected string
+	}{
+		{
+			FormatLogfmt,
+			func(l *Logger) { l.Info("served", "status", 200, "path", "/leaks/go/a b") },
+			"time=2018-03-01T12:00:00Z level=info msg=served status=200 path=\"/leaks/go/a b\"\n",
+		},
+		{
+			FormatJSON,
+			func(l *Logger) { l.Info("served", "status", 200, "path", "/leaks/go/a b") },
+			"{\"time\":\"2018-03-01T12:00:00Z\",\"level\":\"info\",\"msg\":\"served\",\"status\":200,\"path\":\"/leaks/go/a b\"}\n",
+		},
+		{
+			FormatLogfmt,
+			func(l *Logger) {
+				l.With("worker", "w1").Error("failed", "err", errors.New("boom"), "took", time.Second)
+			},
+			"time=2018-03-01T12:00:00Z level=error msg=failed worker=w1 err=boom took=1s\n",
+		},
+		{
+			FormatJSON,
+			func(l *Logger) { l.Warn("odd", "key") },
+			"{\"time\":\"2018-03-01T12:00:00Z\",\"level\":\"warn\",\"msg\":\"odd\",\"key\":\"MISSING\"}\n",
+		},
+		{
+			FormatLogfmt,
+			func(l *Logger) { l.Debug("hidden") },
+			"",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expected, func(t *testing.T) {
+			var out bytes.Buffer
+			testCase.log(New(&out, LevelInfo, testCase.format))
+
+			if out.String() != testCase.expected {
+				t.Errorf("expected %q; got %q", testCase.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+
+	testCases := []struct {
+		level string
+		err   bool
+	}{
+		{"debug", false},
+		{"WARN", false},
+		{"error", false},
+		{"verbose", true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.level, func(t *testing.T) {
+			if _, err := ParseLevel(testCase.level); (err != nil) != testCase.err {
+				t.Errorf("expected error %v; got %v", testCase.err, err)
+			}
+		})
+	}
+
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("expected an error for xml")
+	}
+}
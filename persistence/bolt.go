@@ -0,0 +1,454 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	//repositoryBucket holds the repositories by URL
+	repositoryBucket = []byte("repository")
+	//jobBucket holds the jobs by ID
+	jobBucket = []byte("job")
+	//jobKeyBucket holds the job IDs by repo and commit
+	jobKeyBucket = []byte("job_key")
+)
+
+//BoltConfig tells where the bolt store keeps its file
+type BoltConfig struct {
+	Path string `json:"path"`
+}
+
+//boltStore is a Store keeping everything in a file, for the local
+//development. The file can only be opened by one process at a time.
+type boltStore struct {
+	db *bolt.DB
+}
+
+//NewBolt returns a Store keeping everything in the file at config.Path
+func NewBolt(config BoltConfig) (*boltStore, error) {
+	db, err := bolt.Open(config.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{repositoryBucket, jobBucket, jobKeyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+//Ping checks that the database answers
+func (b *boltStore) Ping(ctx context.Context) error {
+	return b.view(ctx, func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+//view runs f in a read-only transaction unless ctx is done. The
+//transactions are local and short; they aren't interrupted.
+func (b *boltStore) view(ctx context.Context, f func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.View(f)
+}
+
+//update runs f in a read-write transaction unless ctx is done
+func (b *boltStore) update(ctx context.Context, f func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(f)
+}
+
+//Close closes the file; the store can't be used afterwards
+func (b *boltStore) Close() {
+	b.db.Close()
+}
+
+//AppendAnalysis appends analysis to the repository at url, created with
+//language if needed or given it if it has none, unless an analysis of the
+//same commit is stored. It reports whether analysis was inserted.
+func (b *boltStore) AppendAnalysis(ctx context.Context, url string, language string, analysis *Analysis) (bool, error) {
+	inserted := false
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(repositoryBucket)
+
+		repository := Repository{URL: url, Language: language, Analysis: []*Analysis{}, SchemaVersion: SchemaVersion}
+		if value := bucket.Get([]byte(url)); value != nil {
+			var err error
+			if repository, err = decodeRepository(value); err != nil {
+				return err
+			}
+		}
+		filled := fillLanguage(&repository, language)
+		inserted = appendAnalysis(&repository, analysis)
+		if !inserted && !filled {
+			return nil
+		}
+		return putRepository(bucket, repository)
+	})
+	if err != nil {
+		return false, err
+	}
+	return inserted, nil
+}
+
+//FindRepo finds a repo
+func (b *boltStore) FindRepo(ctx context.Context, url string) (Repository, error) {
+	var repository Repository
+	err := b.view(ctx, func(tx *bolt.Tx) error {
+		value := tx.Bucket(repositoryBucket).Get([]byte(url))
+		if value == nil {
+			return ErrNotFound
+		}
+		var err error
+		repository, err = decodeRepository(value)
+		return err
+	})
+	return repository, err
+}
+
+//FindAll retuns all the repo
+func (b *boltStore) FindAll(ctx context.Context, language string, skip int, limit int, sort string) ([]Repository, error) {
+	repositories := []Repository{}
+	err := b.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(repositoryBucket).ForEach(func(_, value []byte) error {
+			repository, err := decodeRepository(value)
+			if err != nil {
+				return err
+			}
+			if inLanguage(repository, language) {
+				repositories = append(repositories, repository)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page(sortRepositories(repositories, sort), skip, limit), nil
+}
+
+//Each iterates over the repos with a URL prefix. They are read by batches
+//in their own transactions so that f may write to the store.
+func (b *boltStore) Each(ctx context.Context, prefix string, f func(repository Repository) error) error {
+	//after is the last repository of the previous batch
+	var after []byte
+	for {
+		batch := []Repository{}
+		err := b.view(ctx, func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(repositoryBucket).Cursor()
+			key, value := cursor.Seek([]byte(prefix))
+			if after != nil {
+				key, value = cursor.Seek(after)
+				if bytes.Equal(key, after) {
+					key, value = cursor.Next()
+				}
+			}
+
+			for ; key != nil && bytes.HasPrefix(key, []byte(prefix)) && len(batch) < eachBatch; key, value = cursor.Next() {
+				repository, err := decodeRepository(value)
+				if err != nil {
+					return err
+				}
+				batch = append(batch, repository)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := each(ctx, batch, f); err != nil {
+			return err
+		}
+		if len(batch) < eachBatch {
+			return nil
+		}
+		after = []byte(batch[len(batch)-1].URL)
+	}
+}
+
+//TouchRepo records when a repo was requested
+func (b *boltStore) TouchRepo(ctx context.Context, url string, requested int64) error {
+	return b.updateRepo(ctx, url, func(repository *Repository) error {
+		repository.Requested = requested
+		return nil
+	})
+}
+
+//DeleteAnalyses deletes analyses of a repo by commit
+func (b *boltStore) DeleteAnalyses(ctx context.Context, url string, hashes []string, revision Revision) error {
+	return b.updateRepo(ctx, url, func(repository *Repository) error {
+		if repository.Revision() != revision {
+			return ErrChanged
+		}
+		removeAnalyses(repository, hashes)
+		return nil
+	})
+}
+
+//DeleteRepo deletes a repo
+func (b *boltStore) DeleteRepo(ctx context.Context, url string, revision Revision) error {
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(repositoryBucket)
+		value := bucket.Get([]byte(url))
+		if value == nil {
+			return ErrNotFound
+		}
+
+		repository, err := decodeRepository(value)
+		if err != nil {
+			return err
+		}
+		if repository.Revision() != revision {
+			return ErrChanged
+		}
+		return bucket.Delete([]byte(url))
+	})
+}
+
+//updateRepo runs update on the repository at url and stores it, unless
+//update fails
+func (b *boltStore) updateRepo(ctx context.Context, url string, update func(repository *Repository) error) error {
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(repositoryBucket)
+		value := bucket.Get([]byte(url))
+		if value == nil {
+			return ErrNotFound
+		}
+
+		repository, err := decodeRepository(value)
+		if err != nil {
+			return err
+		}
+		if err := update(&repository); err != nil {
+			return err
+		}
+		return putRepository(bucket, repository)
+	})
+}
+
+//MigrateDocuments stores the upgraded repositories of older schema versions
+func (b *boltStore) MigrateDocuments(ctx context.Context) (int, error) {
+	count := 0
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(repositoryBucket)
+
+		//The bucket can't be modified while iterating over it
+		upgraded := map[string]Repository{}
+		err := bucket.ForEach(func(key, value []byte) error {
+			repository, err := unmarshalRepository(value)
+			if err != nil {
+				return err
+			}
+			if upgrade(&repository) {
+				upgraded[string(key)] = repository
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, repository := range upgraded {
+			if err := putRepository(bucket, repository); err != nil {
+				return err
+			}
+		}
+		count = len(upgraded)
+		return nil
+	})
+	return count, err
+}
+
+//decodeRepository decodes a stored repository, upgraded to the current
+//schema version
+func decodeRepository(value []byte) (Repository, error) {
+	repository, err := unmarshalRepository(value)
+	if err != nil {
+		return Repository{}, err
+	}
+	upgrade(&repository)
+	return repository, nil
+}
+
+//boltRepository is a stored repository, along with its schema version
+//that the API doesn't show
+type boltRepository struct {
+	Repository
+	SchemaVersion int `json:"schema_version"`
+}
+
+//unmarshalRepository decodes a stored repository as it is
+func unmarshalRepository(value []byte) (Repository, error) {
+	var stored boltRepository
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return Repository{}, err
+	}
+	stored.Repository.SchemaVersion = stored.SchemaVersion
+	return stored.Repository, nil
+}
+
+//putRepository stores repository under its URL
+func putRepository(bucket *bolt.Bucket, repository Repository) error {
+	return put(bucket, repository.URL, boltRepository{Repository: repository, SchemaVersion: repository.SchemaVersion})
+}
+
+//EnqueueJob queues the analysis of commit of repo (user/repo) in language
+//unless it is already queued or running. Failed jobs are queued again.
+func (b *boltStore) EnqueueJob(ctx context.Context, repo string, language string, commit string) error {
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		jobs, keys := tx.Bucket(jobBucket), tx.Bucket(jobKeyBucket)
+		key := []byte(repo + "\x00" + commit)
+
+		if id := keys.Get(key); id != nil {
+			var job Job
+			if err := get(jobs, string(id), &job); err != nil {
+				return err
+			}
+			if job.Status != JobFailed {
+				return nil
+			}
+			job.Status, job.Language, job.Created = JobQueued, language, time.Now()
+			return put(jobs, job.ID, job)
+		}
+
+		job := newJob(repo, language, commit)
+		if err := keys.Put(key, []byte(job.ID)); err != nil {
+			return err
+		}
+		return put(jobs, job.ID, job)
+	})
+}
+
+//ClaimJob claims the oldest queued job, or a running job whose lease
+//expired, for worker
+func (b *boltStore) ClaimJob(ctx context.Context, worker string, lease time.Duration) (*Job, error) {
+	var oldest *Job
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(jobBucket)
+		now := time.Now()
+
+		err := jobs.ForEach(func(_, value []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(value, job); err != nil {
+				return err
+			}
+			if claimable(job, now) && (oldest == nil || job.Created.Before(oldest.Created)) {
+				oldest = job
+			}
+			return nil
+		})
+		if err != nil || oldest == nil {
+			return err
+		}
+
+		claim(oldest, worker, now.Add(lease))
+		return put(jobs, oldest.ID, oldest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if oldest == nil {
+		return nil, ErrNoJob
+	}
+	return oldest, nil
+}
+
+//RenewJob extends the lease of a running job
+func (b *boltStore) RenewJob(ctx context.Context, job *Job, lease time.Duration) error {
+	return b.updateOwned(ctx, job, func(stored *Job) {
+		job.Lease = time.Now().Add(lease)
+		stored.Lease = job.Lease
+	})
+}
+
+//CompleteJob marks a running job as done, or as failed if err isn't nil
+func (b *boltStore) CompleteJob(ctx context.Context, job *Job, err error) error {
+	complete(job, err)
+	return b.updateOwned(ctx, job, func(stored *Job) {
+		stored.Status, stored.Error = job.Status, job.Error
+	})
+}
+
+//ReleaseJob queues a running job again, for a worker stopping before the
+//job completes. The attempt isn't counted.
+func (b *boltStore) ReleaseJob(ctx context.Context, job *Job) error {
+	err := b.updateOwned(ctx, job, release)
+	if err == nil {
+		job.Status = JobQueued
+	}
+	return err
+}
+
+//CountJobs counts the jobs with status
+func (b *boltStore) CountJobs(ctx context.Context, status string) (int, error) {
+	count := 0
+	err := b.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(jobBucket).ForEach(func(_, value []byte) error {
+			var job Job
+			if err := json.Unmarshal(value, &job); err != nil {
+				return err
+			}
+			if job.Status == status {
+				count++
+			}
+			return nil
+		})
+	})
+	return count, err
+}
+
+//updateOwned applies update to the stored job if it is still running for
+//the worker of job
+func (b *boltStore) updateOwned(ctx context.Context, job *Job, update func(stored *Job)) error {
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(jobBucket)
+
+		var stored Job
+		if err := get(jobs, job.ID, &stored); err != nil {
+			return err
+		}
+		if stored.Worker != job.Worker || stored.Status != JobRunning {
+			return ErrLeaseLost
+		}
+
+		update(&stored)
+		return put(jobs, stored.ID, stored)
+	})
+}
+
+//put stores value as JSON under key
+func put(bucket *bolt.Bucket, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), encoded)
+}
+
+//get reads the JSON stored under key, ErrLeaseLost if there is none
+func get(bucket *bolt.Bucket, key string, value interface{}) error {
+	encoded := bucket.Get([]byte(key))
+	if encoded == nil {
+		return ErrLeaseLost
+	}
+	return json.Unmarshal(encoded, value)
+}
@@ -0,0 +1,176 @@
+package persistence
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//copyRepository returns a deep copy of repository so that the stored
+//documents can't be modified by the callers
+func copyRepository(repository Repository) Repository {
+	analyses := make([]*Analysis, len(repository.Analysis))
+	for i, analysis := range repository.Analysis {
+		copied := *analysis
+		copied.Leaks = make([]*Leak, len(analysis.Leaks))
+		for j, leak := range analysis.Leaks {
+			leakCopy := *leak
+			if leak.Configurations != nil {
+				leakCopy.Configurations = append([]string{}, leak.Configurations...)
+			}
+			copied.Leaks[j] = &leakCopy
+		}
+		analyses[i] = &copied
+	}
+	repository.Analysis = analyses
+	return repository
+}
+
+//appendAnalysis inserts analysis in repository by time, after the ones of
+//the same time, unless an analysis of the same commit is there, and tells
+//whether it did. The score becomes the one of analysis if it is the latest.
+func appendAnalysis(repository *Repository, analysis *Analysis) bool {
+	for _, existing := range repository.Analysis {
+		if existing.Hash == analysis.Hash {
+			return false
+		}
+	}
+
+	i := sort.Search(len(repository.Analysis), func(i int) bool {
+		return repository.Analysis[i].Time > analysis.Time
+	})
+	repository.Analysis = append(repository.Analysis, nil)
+	copy(repository.Analysis[i+1:], repository.Analysis[i:])
+	repository.Analysis[i] = analysis
+
+	if i == len(repository.Analysis)-1 {
+		repository.Score, repository.Grade = analysis.Score, analysis.Grade
+	}
+	return true
+}
+
+//fillLanguage gives language to repository if it was stored without one,
+//and tells whether it did
+func fillLanguage(repository *Repository, language string) bool {
+	if repository.Language != "" || language == "" {
+		return false
+	}
+	repository.Language = language
+	return true
+}
+
+//inLanguage tells whether FindAll selects repository for language
+func inLanguage(repository Repository, language string) bool {
+	return language == "" || repository.Language == language
+}
+
+//removeAnalyses removes the analyses of the commits hashes from repository
+func removeAnalyses(repository *Repository, hashes []string) {
+	removed := map[string]bool{}
+	for _, hash := range hashes {
+		removed[hash] = true
+	}
+
+	kept := []*Analysis{}
+	for _, analysis := range repository.Analysis {
+		if !removed[analysis.Hash] {
+			kept = append(kept, analysis)
+		}
+	}
+	repository.Analysis = kept
+}
+
+//eachBatch is the number of repositories the stores read at once in Each
+const eachBatch = 100
+
+//each calls f with repositories until it fails or ctx is done
+func each(ctx context.Context, repositories []Repository, f func(repository Repository) error) error {
+	for _, repository := range repositories {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(repository); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//sortRepositories sorts repositories as FindAll; the order is kept when
+//sort is empty
+func sortRepositories(repositories []Repository, order string) []Repository {
+	if order == "" {
+		return repositories
+	}
+
+	field := strings.TrimPrefix(order, "-")
+	less := func(a, b Repository) bool {
+		if field == "score" {
+			return a.Score < b.Score
+		}
+		return a.URL < b.URL
+	}
+	if strings.HasPrefix(order, "-") {
+		ascending := less
+		less = func(a, b Repository) bool {
+			return ascending(b, a)
+		}
+	}
+
+	sort.SliceStable(repositories, func(i, j int) bool {
+		return less(repositories[i], repositories[j])
+	})
+	return repositories
+}
+
+//page returns limit repositories after the first skip ones
+func page(repositories []Repository, skip int, limit int) []Repository {
+	if skip >= len(repositories) {
+		return []Repository{}
+	}
+	repositories = repositories[skip:]
+	if limit > 0 && limit < len(repositories) {
+		repositories = repositories[:limit]
+	}
+	return repositories
+}
+
+//newJob returns a queued job
+func newJob(repo string, language string, commit string) *Job {
+	return &Job{
+		ID:       bson.NewObjectId().Hex(),
+		Repo:     repo,
+		Language: language,
+		Commit:   commit,
+		Status:   JobQueued,
+		Created:  time.Now(),
+	}
+}
+
+//claimable tells whether job is queued or its lease expired at now
+func claimable(job *Job, now time.Time) bool {
+	return job.Status == JobQueued || (job.Status == JobRunning && job.Lease.Before(now))
+}
+
+//claim marks job as running for worker until lease
+func claim(job *Job, worker string, lease time.Time) {
+	job.Status, job.Worker, job.Lease = JobRunning, worker, lease
+	job.Attempts++
+}
+
+//complete sets the status of job according to err
+func complete(job *Job, err error) {
+	job.Status, job.Error = JobDone, ""
+	if err != nil {
+		job.Status, job.Error = JobFailed, err.Error()
+	}
+}
+
+//release queues job again without counting the attempt
+func release(job *Job) {
+	job.Status, job.Worker = JobQueued, ""
+	job.Attempts--
+}
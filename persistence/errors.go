@@ -0,0 +1,35 @@
+package persistence
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+//ErrNotFound is returned when a repository isn't stored
+var ErrNotFound = errors.New("repository not found")
+
+//ErrChanged is returned when a repository changed since it was read
+var ErrChanged = errors.New("repository changed since it was read")
+
+//IsUnavailable tells whether err means that the store can't be reached,
+//rather than that the operation failed
+func IsUnavailable(err error) bool {
+	switch err {
+	case nil:
+		return false
+	case io.EOF, io.ErrUnexpectedEOF, driver.ErrBadConn, bolt.ErrTimeout, bolt.ErrDatabaseNotOpen:
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	//mgo doesn't export its connection errors
+	message := err.Error()
+	return strings.Contains(message, "no reachable servers") || strings.Contains(message, "Closed explicitly")
+}
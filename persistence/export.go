@@ -0,0 +1,117 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+//Record is a line of the exports: an analysis of a repository
+type Record struct {
+	URL       string    `json:"url"`
+	Language  string    `json:"language"`
+	Requested int64     `json:"requested,omitempty"`
+	Analysis  *Analysis `json:"analysis"`
+}
+
+//Filter selects the analyses exported or imported
+type Filter struct {
+	//Prefix selects the repositories whose URL starts with it
+	Prefix string
+	//Since and Until select the analyses made in [Since, Until); they are
+	//unbounded when zero
+	Since time.Time
+	Until time.Time
+}
+
+//match tells whether filter selects analysis of the repository at url
+func (f Filter) match(url string, analysis *Analysis) bool {
+	made := time.Unix(analysis.Time, 0)
+	return strings.HasPrefix(url, f.Prefix) &&
+		(f.Since.IsZero() || !made.Before(f.Since)) &&
+		(f.Until.IsZero() || made.Before(f.Until))
+}
+
+//Export writes the analyses of dao selected by filter to w as JSON records,
+//one per line, by repository and in the order they were made. It returns
+//the number of records written.
+func Export(ctx context.Context, dao DAO, w io.Writer, filter Filter) (int, error) {
+	encoder := json.NewEncoder(w)
+	count := 0
+	err := dao.Each(ctx, filter.Prefix, func(repository Repository) error {
+		for _, analysis := range repository.Analysis {
+			if !filter.match(repository.URL, analysis) {
+				continue
+			}
+			err := encoder.Encode(Record{
+				URL:       repository.URL,
+				Language:  repository.Language,
+				Requested: repository.Requested,
+				Analysis:  analysis,
+			})
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+//Import appends the records of an export read from r and selected by
+//filter to dao, among the stored analyses by time. The analyses already
+//stored are left as they are so that an import can be run again. It returns
+//the number of analyses inserted, without the ones already stored.
+func Import(ctx context.Context, dao DAO, r io.Reader, filter Filter) (int, error) {
+	decoder := json.NewDecoder(r)
+	count := 0
+	//last is the repository of the previous record; the records of a
+	//repository follow each other
+	last := ""
+	for line := 1; ; line++ {
+		var record Record
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, fmt.Errorf("record %d: %v", line, err)
+		}
+		if record.URL == "" || record.Analysis == nil || record.Analysis.Hash == "" {
+			return count, fmt.Errorf("record %d: url and analysis.hash are required", line)
+		}
+
+		if !filter.match(record.URL, record.Analysis) {
+			continue
+		}
+		inserted, err := dao.AppendAnalysis(ctx, record.URL, record.Language, record.Analysis)
+		if err != nil {
+			return count, err
+		}
+		if record.URL != last && record.Requested > 0 {
+			if err := touchLater(ctx, dao, record.URL, record.Requested); err != nil {
+				return count, err
+			}
+		}
+		last = record.URL
+		if inserted {
+			count++
+		}
+	}
+}
+
+//touchLater records that the repository at url was requested at requested
+//unless it was requested later
+func touchLater(ctx context.Context, dao DAO, url string, requested int64) error {
+	repository, err := dao.FindRepo(ctx, url)
+	if err != nil {
+		return err
+	}
+	if repository.Requested >= requested {
+		return nil
+	}
+	return dao.TouchRepo(ctx, url, requested)
+}
@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//Job is a repository analysis waiting to be run by a worker
+type Job struct {
+	ID       string    `json:"id" bson:"_id"`
+	Repo     string    `json:"repo"`
+	Language string    `json:"language"`
+	Commit   string    `json:"commit"`
+	Status   string    `json:"status"`
+	Worker   string    `json:"worker,omitempty"`
+	Lease    time.Time `json:"lease"`
+	Attempts int       `json:"attempts"`
+	Created  time.Time `json:"created"`
+	Error    string    `json:"error,omitempty"`
+}
+
+const (
+	//JobQueued is the status of a job waiting for a worker
+	JobQueued = "queued"
+	//JobRunning is the status of a job claimed by a worker
+	JobRunning = "running"
+	//JobDone is the status of a job whose analysis is stored
+	JobDone = "done"
+	//JobFailed is the status of a job that could not be completed
+	JobFailed = "failed"
+)
+
+var (
+	//ErrNoJob is returned when there is no job to claim
+	ErrNoJob = errors.New("no job to claim")
+	//ErrLeaseLost is returned when a job was claimed by another worker
+	ErrLeaseLost = errors.New("job lease lost")
+)
+
+//Queue defines the interface for the analysis job queue
+type Queue interface {
+	EnqueueJob(ctx context.Context, repo string, language string, commit string) error
+	ClaimJob(ctx context.Context, worker string, lease time.Duration) (*Job, error)
+	RenewJob(ctx context.Context, job *Job, lease time.Duration) error
+	CompleteJob(ctx context.Context, job *Job, err error) error
+	ReleaseJob(ctx context.Context, job *Job) error
+	CountJobs(ctx context.Context, status string) (int, error)
+}
+
+func (mg *mongo) ensureJobIndex() error {
+	session := mg.session.Copy()
+	defer session.Close()
+
+	c := session.DB(mg.dbName).C("job")
+
+	for _, index := range []mgo.Index{
+		{Key: []string{"repo", "commit"}, Unique: true},
+		{Key: []string{"status", "created"}},
+	} {
+		if err := c.EnsureIndex(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//EnqueueJob queues the analysis of commit of repo (user/repo) in language
+//unless it is already queued or running. Failed jobs are queued again.
+func (mg *mongo) EnqueueJob(ctx context.Context, repo string, language string, commit string) error {
+	return mg.run(ctx, "enqueue_job", func(db *mgo.Database) error {
+		c := db.C("job")
+
+		selector := bson.M{"repo": repo, "commit": commit}
+		update := bson.M{
+			"$setOnInsert": bson.M{
+				"_id":      bson.NewObjectId().Hex(),
+				"language": language,
+				"status":   JobQueued,
+				"attempts": 0,
+				"created":  time.Now(),
+			},
+		}
+		_, err := c.Upsert(selector, update)
+		if mgo.IsDup(err) {
+			//The job was inserted meanwhile; the retry matches it
+			_, err = c.Upsert(selector, update)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = c.Update(
+			bson.M{"repo": repo, "commit": commit, "status": JobFailed},
+			bson.M{"$set": bson.M{"status": JobQueued, "language": language, "created": time.Now()}},
+		)
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+//ClaimJob atomically claims the oldest queued job, or a running job whose
+//lease expired, for worker
+func (mg *mongo) ClaimJob(ctx context.Context, worker string, lease time.Duration) (*Job, error) {
+	now := time.Now()
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{"status": JobRunning, "worker": worker, "lease": now.Add(lease)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		ReturnNew: true,
+	}
+
+	var job Job
+	err := mg.run(ctx, "claim_job", func(db *mgo.Database) error {
+		_, err := db.C("job").Find(bson.M{"$or": []bson.M{
+			{"status": JobQueued},
+			{"status": JobRunning, "lease": bson.M{"$lt": now}},
+		}}).Sort("created").Apply(change, &job)
+		return err
+	})
+
+	if err == mgo.ErrNotFound {
+		return nil, ErrNoJob
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+//updateJob applies update to job if it is still running for its worker
+func (mg *mongo) updateJob(ctx context.Context, operation string, job *Job, update bson.M) error {
+	err := mg.run(ctx, operation, func(db *mgo.Database) error {
+		return db.C("job").Update(bson.M{"_id": job.ID, "worker": job.Worker, "status": JobRunning}, update)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+//RenewJob extends the lease of a running job
+func (mg *mongo) RenewJob(ctx context.Context, job *Job, lease time.Duration) error {
+	job.Lease = time.Now().Add(lease)
+	return mg.updateJob(ctx, "renew_job", job, bson.M{"$set": bson.M{"lease": job.Lease}})
+}
+
+//CompleteJob marks a running job as done, or as failed if err isn't nil
+func (mg *mongo) CompleteJob(ctx context.Context, job *Job, err error) error {
+	complete(job, err)
+	return mg.updateJob(ctx, "complete_job", job, bson.M{"$set": bson.M{"status": job.Status, "error": job.Error}})
+}
+
+//ReleaseJob queues a running job again, for a worker stopping before the
+//job completes. The attempt isn't counted.
+func (mg *mongo) ReleaseJob(ctx context.Context, job *Job) error {
+	err := mg.updateJob(ctx, "release_job", job, bson.M{
+		"$set": bson.M{"status": JobQueued, "worker": ""},
+		"$inc": bson.M{"attempts": -1},
+	})
+	if err == nil {
+		job.Status = JobQueued
+	}
+	return err
+}
+
+//CountJobs counts the jobs with status
+func (mg *mongo) CountJobs(ctx context.Context, status string) (int, error) {
+	var count int
+	err := mg.run(ctx, "count_jobs", func(db *mgo.Database) error {
+		var err error
+		count, err = db.C("job").Find(bson.M{"status": status}).Count()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,293 @@
+package persistence
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+//memory is a Store keeping everything in memory, for the tests and the
+//local development
+type memory struct {
+	mu           sync.Mutex
+	repositories map[string]Repository
+	//urls are the repositories in insertion order
+	urls []string
+	jobs map[string]*Job
+}
+
+//NewMemory returns an empty in-memory Store
+func NewMemory() *memory {
+	return &memory{
+		repositories: map[string]Repository{},
+		jobs:         map[string]*Job{},
+	}
+}
+
+//Ping checks that the database answers
+func (m *memory) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+//Close closes the store
+func (m *memory) Close() {}
+
+//AppendAnalysis appends analysis to the repository at url, created with
+//language if needed or given it if it has none, unless an analysis of the
+//same commit is stored. It reports whether analysis was inserted.
+func (m *memory) AppendAnalysis(ctx context.Context, url string, language string, analysis *Analysis) (bool, error) {
+	if err := m.lock(ctx); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	repository, ok := m.repositories[url]
+	if !ok {
+		repository = Repository{URL: url, Language: language, Analysis: []*Analysis{}, SchemaVersion: SchemaVersion}
+		m.urls = append(m.urls, url)
+	}
+	filled := fillLanguage(&repository, language)
+	inserted := appendAnalysis(&repository, analysis)
+	if !inserted && !filled {
+		return false, nil
+	}
+	m.repositories[url] = copyRepository(repository)
+	return inserted, nil
+}
+
+//FindRepo finds a repo
+func (m *memory) FindRepo(ctx context.Context, url string) (Repository, error) {
+	if err := m.lock(ctx); err != nil {
+		return Repository{}, err
+	}
+	defer m.mu.Unlock()
+
+	repository, ok := m.repositories[url]
+	if !ok {
+		return Repository{}, ErrNotFound
+	}
+	return copyRepository(repository), nil
+}
+
+//FindAll retuns all the repo
+func (m *memory) FindAll(ctx context.Context, language string, skip int, limit int, sort string) ([]Repository, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	repositories := make([]Repository, 0, len(m.urls))
+	for _, url := range m.urls {
+		if inLanguage(m.repositories[url], language) {
+			repositories = append(repositories, copyRepository(m.repositories[url]))
+		}
+	}
+	return page(sortRepositories(repositories, sort), skip, limit), nil
+}
+
+//Each iterates over the repos with a URL prefix. They are copied first so
+//that f may call the store.
+func (m *memory) Each(ctx context.Context, prefix string, f func(repository Repository) error) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	repositories := []Repository{}
+	for _, url := range m.urls {
+		if strings.HasPrefix(url, prefix) {
+			repositories = append(repositories, copyRepository(m.repositories[url]))
+		}
+	}
+	m.mu.Unlock()
+
+	return each(ctx, sortRepositories(repositories, "url"), f)
+}
+
+//TouchRepo records when a repo was requested
+func (m *memory) TouchRepo(ctx context.Context, url string, requested int64) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	repository, ok := m.repositories[url]
+	if !ok {
+		return ErrNotFound
+	}
+	repository.Requested = requested
+	m.repositories[url] = repository
+	return nil
+}
+
+//DeleteAnalyses deletes analyses of a repo by commit
+func (m *memory) DeleteAnalyses(ctx context.Context, url string, hashes []string, revision Revision) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	repository, ok := m.repositories[url]
+	if !ok {
+		return ErrNotFound
+	}
+	if repository.Revision() != revision {
+		return ErrChanged
+	}
+	removeAnalyses(&repository, hashes)
+	m.repositories[url] = repository
+	return nil
+}
+
+//DeleteRepo deletes a repo
+func (m *memory) DeleteRepo(ctx context.Context, url string, revision Revision) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	repository, ok := m.repositories[url]
+	if !ok {
+		return ErrNotFound
+	}
+	if repository.Revision() != revision {
+		return ErrChanged
+	}
+	delete(m.repositories, url)
+	for i, stored := range m.urls {
+		if stored == url {
+			m.urls = append(m.urls[:i], m.urls[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+//EnqueueJob queues the analysis of commit of repo (user/repo) in language
+//unless it is already queued or running. Failed jobs are queued again.
+func (m *memory) EnqueueJob(ctx context.Context, repo string, language string, commit string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.Repo == repo && job.Commit == commit {
+			if job.Status == JobFailed {
+				job.Status, job.Language, job.Created = JobQueued, language, time.Now()
+			}
+			return nil
+		}
+	}
+
+	job := newJob(repo, language, commit)
+	m.jobs[job.ID] = job
+	return nil
+}
+
+//ClaimJob claims the oldest queued job, or a running job whose lease
+//expired, for worker
+func (m *memory) ClaimJob(ctx context.Context, worker string, lease time.Duration) (*Job, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var oldest *Job
+	for _, job := range m.jobs {
+		if claimable(job, now) && (oldest == nil || job.Created.Before(oldest.Created)) {
+			oldest = job
+		}
+	}
+	if oldest == nil {
+		return nil, ErrNoJob
+	}
+
+	claim(oldest, worker, now.Add(lease))
+	claimed := *oldest
+	return &claimed, nil
+}
+
+//RenewJob extends the lease of a running job
+func (m *memory) RenewJob(ctx context.Context, job *Job, lease time.Duration) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	stored, ok := m.owned(job)
+	if !ok {
+		return ErrLeaseLost
+	}
+	job.Lease = time.Now().Add(lease)
+	stored.Lease = job.Lease
+	return nil
+}
+
+//CompleteJob marks a running job as done, or as failed if err isn't nil
+func (m *memory) CompleteJob(ctx context.Context, job *Job, err error) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	complete(job, err)
+	stored, ok := m.owned(job)
+	if !ok {
+		return ErrLeaseLost
+	}
+	stored.Status, stored.Error = job.Status, job.Error
+	return nil
+}
+
+//ReleaseJob queues a running job again, for a worker stopping before the
+//job completes. The attempt isn't counted.
+func (m *memory) ReleaseJob(ctx context.Context, job *Job) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	stored, ok := m.owned(job)
+	if !ok {
+		return ErrLeaseLost
+	}
+	release(stored)
+	job.Status = JobQueued
+	return nil
+}
+
+//CountJobs counts the jobs with status
+func (m *memory) CountJobs(ctx context.Context, status string) (int, error) {
+	if err := m.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, job := range m.jobs {
+		if job.Status == status {
+			count++
+		}
+	}
+	return count, nil
+}
+
+//lock locks the store unless ctx is done
+func (m *memory) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	return nil
+}
+
+//owned returns the stored job if it is still running for the worker of job.
+//m.mu must be held.
+func (m *memory) owned(job *Job) (*Job, bool) {
+	stored, ok := m.jobs[job.ID]
+	if !ok || stored.Worker != job.Worker || stored.Status != JobRunning {
+		return nil, false
+	}
+	return stored, true
+}
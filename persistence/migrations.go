@@ -0,0 +1,58 @@
+package persistence
+
+import "context"
+
+//documentMigration upgrades a stored repository from the previous schema
+//version
+type documentMigration struct {
+	description string
+	up          func(repository *Repository)
+}
+
+//documentMigrations are the changes of the shape of the repositories kept
+//as documents, applied in order: the migration at i upgrades the documents
+//of version i. Released migrations must not be modified; new ones are
+//appended.
+var documentMigrations = []documentMigration{
+	{"leaks have a kind", func(repository *Repository) {
+		for _, analysis := range repository.Analysis {
+			for _, leak := range analysis.Leaks {
+				if leak.Kind == "" {
+					leak.Kind = LeakKindOf(leak.Message)
+				}
+			}
+		}
+	}},
+	{"analyses have a status", func(repository *Repository) {
+		//Only the completed analyses were stored before
+		for _, analysis := range repository.Analysis {
+			if analysis.Status == "" {
+				analysis.Status = StatusDone
+			}
+		}
+	}},
+}
+
+//SchemaVersion is the version of the repositories written by this version
+var SchemaVersion = len(documentMigrations)
+
+//upgrade applies the migrations repository lacks, and tells whether there
+//were any
+func upgrade(repository *Repository) bool {
+	if repository.SchemaVersion >= SchemaVersion {
+		return false
+	}
+	for _, migration := range documentMigrations[repository.SchemaVersion:] {
+		migration.up(repository)
+	}
+	repository.SchemaVersion = SchemaVersion
+	return true
+}
+
+//Migrator is a store keeping the repositories as documents. They are
+//upgraded when read, and can be upgraded in bulk to skip it.
+type Migrator interface {
+	//MigrateDocuments stores the upgraded repositories of older schema
+	//versions and returns how many there were
+	MigrateDocuments(ctx context.Context) (int, error)
+}
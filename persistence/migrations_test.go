@@ -0,0 +1,214 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDocumentMigrations(t *testing.T) {
+
+	testCases := []struct {
+		description string
+		before      Repository
+		after       Repository
+	}{
+		{
+			"leaks have a kind",
+			Repository{Analysis: []*Analysis{{Leaks: []*Leak{
+				{Message: "a.T is a vendorized type from b"},
+				{Message: "a.T exposes b.U"},
+				{Message: "a.T exposes b.U", Kind: LeakVendored},
+			}}}},
+			Repository{Analysis: []*Analysis{{Leaks: []*Leak{
+				{Message: "a.T is a vendorized type from b", Kind: LeakVendored},
+				{Message: "a.T exposes b.U", Kind: LeakGlobal},
+				{Message: "a.T exposes b.U", Kind: LeakVendored},
+			}}}},
+		},
+		{
+			"analyses have a status",
+			Repository{Analysis: []*Analysis{{Hash: "a"}, {Hash: "b", Status: StatusRefused}}},
+			Repository{Analysis: []*Analysis{{Hash: "a", Status: StatusDone}, {Hash: "b", Status: StatusRefused}}},
+		},
+	}
+
+	if len(testCases) != len(documentMigrations) {
+		t.Fatalf("expected a test case for each of the %d migrations; got %d", len(documentMigrations), len(testCases))
+	}
+
+	for i, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			migration := documentMigrations[i]
+			if migration.description != testCase.description {
+				t.Fatalf("expected migration %d to be %q; got %q", i, testCase.description, migration.description)
+			}
+
+			migration.up(&testCase.before)
+			if !reflect.DeepEqual(testCase.before, testCase.after) {
+				before, _ := json.Marshal(testCase.before)
+				after, _ := json.Marshal(testCase.after)
+				t.Errorf("expected %s; got %s", after, before)
+			}
+		})
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+
+	repository := Repository{Analysis: []*Analysis{{Leaks: []*Leak{{Message: "a.T exposes b.U"}}}}}
+	if !upgrade(&repository) {
+		t.Fatalf("expected a repository without version to be upgraded")
+	}
+	if repository.SchemaVersion != SchemaVersion || repository.Latest().Status != StatusDone || repository.Latest().Leaks[0].Kind != LeakGlobal {
+		t.Errorf("expected every migration to be applied; got %+v", repository.Latest())
+	}
+
+	//The migrations up to the version of the repository are skipped
+	repository = Repository{SchemaVersion: 1, Analysis: []*Analysis{{Leaks: []*Leak{{Message: "a.T exposes b.U"}}}}}
+	upgrade(&repository)
+	if repository.Latest().Status != StatusDone || repository.Latest().Leaks[0].Kind != "" {
+		t.Errorf("expected only the migrations after version 1; got %+v", repository.Latest())
+	}
+
+	if upgrade(&repository) {
+		t.Errorf("expected a repository of the current version to be left as is")
+	}
+}
+
+//withMigration appends migration to the document migrations, and returns a
+//function removing it
+func withMigration(migration documentMigration) func() {
+	migrations, version := documentMigrations, SchemaVersion
+	documentMigrations = append(documentMigrations[:len(migrations):len(migrations)], migration)
+	SchemaVersion = len(documentMigrations)
+	return func() {
+		documentMigrations, SchemaVersion = migrations, version
+	}
+}
+
+//graded is a migration changing a field other than the analyses
+var graded = documentMigration{"repositories are graded", func(repository *Repository) {
+	repository.Grade = "upgraded"
+}}
+
+//oldRepository is a repository stored before the schema was versioned
+const oldRepository = `{"url": "github.com/depbleed/go", "Language": "GO", "analysis": [
+	{"hash": "a", "timestamp": 1, "leaks": [{"file": "a.go", "message": "a.T exposes b.U"}]}
+]}`
+
+func TestBoltMigrateDocuments(t *testing.T) {
+
+	defer withMigration(graded)()
+
+	dir, err := ioutil.TempDir("", "depbleed-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewBolt(BoltConfig{Path: filepath.Join(dir, "depbleed.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	stored := func() string {
+		var value []byte
+		store.db.View(func(tx *bolt.Tx) error {
+			value = append(value, tx.Bucket(repositoryBucket).Get([]byte("github.com/depbleed/go"))...)
+			return nil
+		})
+		return string(value)
+	}
+	store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(repositoryBucket).Put([]byte("github.com/depbleed/go"), []byte(oldRepository))
+	})
+
+	repository, err := store.FindRepo(context.Background(), "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repository.Language != "GO" || repository.SchemaVersion != SchemaVersion || repository.Latest().Status != StatusDone {
+		t.Errorf("expected the repository to be upgraded on read; got %+v", repository)
+	}
+	if stored() != oldRepository {
+		t.Errorf("expected the read to leave the stored repository as is; got %s", stored())
+	}
+
+	for _, expected := range []int{1, 0} {
+		count, err := store.MigrateDocuments(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Errorf("expected %d repositories migrated; got %d", expected, count)
+		}
+	}
+
+	migrated, err := unmarshalRepository([]byte(stored()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.SchemaVersion != SchemaVersion || migrated.Latest().Leaks[0].Kind != LeakGlobal || migrated.Grade != "upgraded" {
+		t.Errorf("expected the stored repository to be upgraded; got %s", stored())
+	}
+}
+
+//TestMongoMigrateDocuments runs against the server at
+//$DEPBLEED_TEST_MONGO_URL, in a new database
+func TestMongoMigrateDocuments(t *testing.T) {
+	url := os.Getenv("DEPBLEED_TEST_MONGO_URL")
+	if url == "" {
+		t.Skip("$DEPBLEED_TEST_MONGO_URL isn't set")
+	}
+	defer withMigration(graded)()
+
+	db := "depbleed_test_" + bson.NewObjectId().Hex()
+	store, err := NewMongo(MongoConfig{URL: url, DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	defer store.session.DB(db).DropDatabase()
+
+	err = store.session.DB(db).C("repository").Insert(bson.M{
+		"url":      "github.com/depbleed/go",
+		"language": "GO",
+		"analysis": []bson.M{{"hash": "a", "leaks": []bson.M{{"message": "a.T exposes b.U"}}}},
+	}, bson.M{
+		"url":            "github.com/depbleed/backend",
+		"language":       "GO",
+		"analysis":       []bson.M{},
+		"schema_version": SchemaVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{1, 0} {
+		count, err := store.MigrateDocuments(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Errorf("expected %d repositories migrated; got %d", expected, count)
+		}
+	}
+
+	var migrated Repository
+	err = store.session.DB(db).C("repository").Find(bson.M{"url": "github.com/depbleed/go"}).One(&migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.SchemaVersion != SchemaVersion || migrated.Latest().Status != StatusDone || migrated.Grade != "upgraded" {
+		t.Errorf("expected the stored repository to be upgraded; got %+v", migrated)
+	}
+}
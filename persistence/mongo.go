@@ -1,9 +1,15 @@
 package persistence
 
 import (
-	"os"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -12,22 +18,75 @@ import (
 type Repository struct {
 	URL      string      `json:"url"`
 	Analysis []*Analysis `json:"analysis"`
-	Language string
+	Language string      `json:"language"`
+	//Score and Grade are the ones of the latest analysis, for sorting
+	Score float64 `json:"score"`
+	Grade string  `json:"grade"`
+	//Requested is when the repository was last requested, in seconds since
+	//the epoch; 0 if it wasn't since it is tracked
+	Requested int64 `json:"requested,omitempty"`
+	//SchemaVersion is the version of the shape of the stored document; it
+	//isn't part of the API
+	SchemaVersion int `json:"-" bson:"schema_version"`
+}
+
+//Latest returns the last analysis of the repository, or nil if it was
+//never analyzed
+func (r Repository) Latest() *Analysis {
+	if len(r.Analysis) == 0 {
+		return nil
+	}
+	return r.Analysis[len(r.Analysis)-1]
 }
 
 //Analysis represents a leak analysis
 type Analysis struct {
-	Leaks []*Leak `json:"leaks"`
-	Hash  string  `json:"hash"`
-	Time  int64   `json:"timestamp"`
+	Leaks    []*Leak `json:"leaks"`
+	Hash     string  `json:"hash"`
+	Time     int64   `json:"timestamp"`
+	Status   string  `json:"status"`
+	Reason   string  `json:"reason,omitempty"`
+	Exported int     `json:"exported"`
+	Score    float64 `json:"score"`
+	Grade    string  `json:"grade"`
 }
 
+const (
+	//StatusDone is the status of a completed analysis
+	StatusDone = "done"
+	//StatusRefused is the status of an analysis refused because the
+	//repository exceeds the limits
+	StatusRefused = "refused"
+	//StatusFailed is the status of an analysis that could not be completed
+	StatusFailed = "failed"
+)
+
 //Leak represents one dependency leak
 type Leak struct {
 	File    string `json:"file"`
 	Line    int    `json:"line"`
 	Column  int    `json:"column"`
 	Message string `json:"message"`
+	Kind    string `json:"kind"`
+	//Configurations are the builds the leak is found in, like
+	//windows/amd64, when several are analyzed
+	Configurations []string `json:"configurations,omitempty" bson:",omitempty"`
+}
+
+const (
+	//LeakVendored is the kind of a leak exposing a vendored type
+	LeakVendored = "vendored"
+	//LeakGlobal is the kind of a leak exposing a type of another package
+	LeakGlobal = "global"
+)
+
+//LeakKindOf returns the kind of a leak from its message, for the leaks
+//stored before their kind was
+func LeakKindOf(message string) string {
+	if strings.Contains(message, "is a vendorized type from") {
+		return LeakVendored
+	}
+	return LeakGlobal
 }
 
 type Infos struct {
@@ -35,65 +94,170 @@ type Infos struct {
 	Leaks    int `json:"leaks"`
 }
 
+//operationDuration is the latency of the MongoDB operations
+var operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "depbleed_mongo_operation_duration_seconds",
+	Help: "Latency of the MongoDB operations.",
+}, []string{"operation"})
+
+func init() {
+	prometheus.MustRegister(operationDuration)
+}
+
+//observe records the latency of operation, started at start
+func observe(operation string, start time.Time) {
+	operationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
 //mongo is a DAO implementation for MongoDB
 type mongo struct {
-	dbName      string
-	dbUser      string
-	dbPassword  string
-	dbAddress   string
-	session     *mgo.Session
-	credentials *mgo.Credential
+	dbName  string
+	session *mgo.Session
 }
 
 //DAO defines the interface for a repository DAO
 type DAO interface {
-	UpdateRepo(repository Repository) error
-	InsertRepo(repository Repository) error
-	FindRepo(url string) (Repository, error)
-	FindAll(skip int, limit int) ([]Repository, error)
+	//AppendAnalysis inserts analysis in the analyses of the repository at
+	//url by time, after the ones of the same time, unless an analysis of the
+	//same commit is stored. The repository is created with language if
+	//needed, and takes it if it has none. Its score becomes the one of
+	//analysis if it is the latest. It reports whether analysis was inserted.
+	AppendAnalysis(ctx context.Context, url string, language string, analysis *Analysis) (bool, error)
+	FindRepo(ctx context.Context, url string) (Repository, error)
+	//FindAll returns the repositories of language, or of every language
+	//when it is empty, sorted by sort
+	FindAll(ctx context.Context, language string, skip int, limit int, sort string) ([]Repository, error)
+	//Each calls f with the repositories whose URL starts with prefix, by
+	//URL, without loading them all at once. It stops at the first error of
+	//f and returns it.
+	Each(ctx context.Context, prefix string, f func(repository Repository) error) error
+	//TouchRepo records that the repository at url was requested at
+	//requested, in seconds since the epoch
+	TouchRepo(ctx context.Context, url string, requested int64) error
+	//DeleteAnalyses deletes the analyses of the commits hashes from the
+	//repository at url, or returns ErrChanged if it isn't at revision
+	//anymore. The score of the repository isn't changed; the latest
+	//analysis should be kept.
+	DeleteAnalyses(ctx context.Context, url string, hashes []string, revision Revision) error
+	//DeleteRepo deletes the repository at url and its analyses, or returns
+	//ErrChanged if it isn't at revision anymore
+	DeleteRepo(ctx context.Context, url string, revision Revision) error
 }
 
-//NewMongo returns a DAO implementation for mongo
-func NewMongo() (*mongo, error) {
+//Store is a storage backend, holding the repositories and the job queue
+type Store interface {
+	DAO
+	Queue
+	//Ping checks that the backend answers
+	Ping(ctx context.Context) error
+	//Close releases the backend; it can't be used afterwards
+	Close()
+}
 
-	mg := &mongo{
-		dbName:     os.Getenv("MONGOD_DB"),
-		dbUser:     os.Getenv("MONGOD_USER"),
-		dbPassword: os.Getenv("MONGOD_PW"),
-		dbAddress:  os.Getenv("MONGOD_URL"),
-		credentials: &mgo.Credential{
-			Username: os.Getenv("MONGOD_USER"),
-			Password: os.Getenv("MONGOD_PW"),
-		},
+//sortFields are the fields FindAll can sort on
+var sortFields = map[string]bool{
+	"url":   true,
+	"score": true,
+}
+
+//ValidSort checks whether sort is a valid sort order for FindAll: a field
+//name optionally prefixed by - for a descending order, or empty
+func ValidSort(sort string) bool {
+	return sort == "" || sortFields[strings.TrimPrefix(sort, "-")]
+}
+
+//MongoConfig tells how to connect to MongoDB. URL is a mongodb:// or
+//mongodb+srv:// connection string, or a host:port address; DB, User and
+//Password override the ones of the URL when set.
+type MongoConfig struct {
+	URL      string `json:"url"`
+	DB       string `json:"db"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+//uri returns the parsed URL with the overrides
+func (c MongoConfig) uri() (*mongoURI, error) {
+	uri, err := parseMongoURI(c.URL)
+	if err != nil {
+		return nil, err
 	}
 
-	mgDBDialInfo := &mgo.DialInfo{
-		Addrs:    []string{mg.dbAddress},
-		Timeout:  60 * time.Second,
-		Database: mg.dbName,
-		Username: mg.dbUser,
-		Password: mg.dbPassword,
+	if c.DB != "" {
+		uri.database = c.DB
+	}
+	if c.User != "" {
+		uri.user = c.User
+	}
+	if c.Password != "" {
+		uri.password = c.Password
 	}
 
-	session, err := mgo.DialWithInfo(mgDBDialInfo)
+	if uri.database == "" {
+		return nil, errors.New("no MongoDB database; set it in the URI or on its own")
+	}
+	return uri, nil
+}
 
+//Validate checks the configuration without connecting
+func (c MongoConfig) Validate() error {
+	_, err := c.uri()
+	return err
+}
+
+//Redacted returns the configuration without the passwords, for printing
+func (c MongoConfig) Redacted() MongoConfig {
+	c.URL = redactURI(c.URL)
+	if c.Password != "" {
+		c.Password = "REDACTED"
+	}
+	return c
+}
+
+//NewMongo returns a DAO implementation for mongo
+func NewMongo(config MongoConfig) (*mongo, error) {
+	uri, err := config.uri()
 	if err != nil {
 		return nil, err
 	}
 
-	mg.session = session
-	err = mg.session.Login(mg.credentials)
+	if err := uri.resolve(net.LookupSRV, net.LookupTXT); err != nil {
+		return nil, err
+	}
 
+	info, err := uri.dialInfo()
 	if err != nil {
 		return nil, err
 	}
 
-	mg.session.SetMode(mgo.Monotonic, true)
-	mg.ensureIndex()
+	//DialWithInfo authenticates with the credentials of info
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to MongoDB at %s: %v", strings.Join(info.Addrs, ","), err)
+	}
+
+	session.SetMode(uri.mode(), true)
+	if safe := uri.safe(); safe != nil {
+		session.SetSafe(safe)
+	}
+
+	mg := &mongo{
+		dbName:  info.Database,
+		session: session,
+	}
+
+	if err := mg.ensureIndex(); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("can't create the MongoDB indexes: %v", err)
+	}
+	if err := mg.ensureJobIndex(); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("can't create the MongoDB indexes: %v", err)
+	}
 	return mg, nil
 }
 
-func (mg *mongo) ensureIndex() {
+func (mg *mongo) ensureIndex() error {
 	session := mg.session.Copy()
 	defer session.Close()
 
@@ -102,54 +266,288 @@ func (mg *mongo) ensureIndex() {
 	index := mgo.Index{
 		Key:        []string{"url"},
 		Unique:     true,
-		DropDups:   true,
 		Background: true,
 		Sparse:     true,
 	}
-	err := c.EnsureIndex(index)
-	if err != nil {
-		panic(err)
+	if err := c.EnsureIndex(index); err != nil {
+		return err
 	}
+
+	//Listings are sorted by score
+	return c.EnsureIndex(mgo.Index{Key: []string{"score"}, Background: true})
 }
 
-//UpdateRepo update a repo
-func (mg *mongo) UpdateRepo(repository Repository) error {
+//run runs f on a copy of the session bounded by the deadline of ctx. mgo
+//can't cancel the operations, so f runs to its end: the socket timeout
+//bounds its calls and the iterations stop once ctx is done.
+func (mg *mongo) run(ctx context.Context, operation string, f func(db *mgo.Database) error) error {
+	defer observe(operation, time.Now())
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	session := mg.session.Copy()
 	defer session.Close()
-	c := session.DB(mg.dbName).C("repository")
-	return c.Update(bson.M{"url": repository.URL}, &repository)
+	if deadline, ok := ctx.Deadline(); ok {
+		session.SetSocketTimeout(time.Until(deadline))
+		session.SetSyncTimeout(time.Until(deadline))
+	}
+
+	err := f(session.DB(mg.dbName))
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return ctxErr
+	}
+	return err
 }
 
-//InsertRepo inserts a repo
-func (mg *mongo) InsertRepo(repository Repository) error {
-	session := mg.session.Copy()
-	defer session.Close()
-	c := session.DB(mg.dbName).C("repository")
-	return c.Insert(repository)
+//Ping checks that the database answers
+func (mg *mongo) Ping(ctx context.Context) error {
+	return mg.run(ctx, "ping", func(db *mgo.Database) error {
+		return db.Session.Ping()
+	})
 }
 
-//FindRepo finds a repo
-func (mg *mongo) FindRepo(url string) (Repository, error) {
-	session := mg.session.Copy()
-	defer session.Close()
+//Close closes the session; the DAO can't be used afterwards
+func (mg *mongo) Close() {
+	mg.session.Close()
+}
+
+//AppendAnalysis inserts analysis in the repository at url in a single
+//upsert, matching the repository only when it has no analysis of the
+//commit. The score is then set if no analysis is more recent.
+func (mg *mongo) AppendAnalysis(ctx context.Context, url string, language string, analysis *Analysis) (bool, error) {
+	inserted := false
+	err := mg.run(ctx, "append_analysis", func(db *mgo.Database) error {
+		c := db.C("repository")
+		selector := bson.M{"url": url, "analysis.hash": bson.M{"$ne": analysis.Hash}}
+		update := bson.M{
+			"$setOnInsert": bson.M{
+				"language":       language,
+				"schema_version": SchemaVersion,
+				"score":          analysis.Score,
+				"grade":          analysis.Grade,
+			},
+			"$push": bson.M{"analysis": bson.M{
+				"$each": []*Analysis{analysis},
+				"$sort": bson.M{"time": 1},
+			}},
+		}
+
+		_, err := c.Upsert(selector, update)
+		if mgo.IsDup(err) {
+			//Either the commit is already stored or the repository was
+			//created meanwhile; it exists now so the retry can't insert
+			_, err = c.Upsert(selector, update)
+		}
+		stored := mgo.IsDup(err)
+		if err != nil && !stored {
+			return err
+		}
 
+		if language != "" {
+			err := c.Update(
+				bson.M{"url": url, "language": bson.M{"$in": []interface{}{"", nil}}},
+				bson.M{"$set": bson.M{"language": language}},
+			)
+			if err != nil && err != mgo.ErrNotFound {
+				return err
+			}
+		}
+		if stored {
+			return nil
+		}
+		inserted = true
+
+		err = c.Update(
+			bson.M{"url": url, "analysis": bson.M{"$not": bson.M{"$elemMatch": bson.M{"time": bson.M{"$gt": analysis.Time}}}}},
+			bson.M{"$set": bson.M{"score": analysis.Score, "grade": analysis.Grade}},
+		)
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return inserted, nil
+}
+
+//FindRepo finds a repo
+func (mg *mongo) FindRepo(ctx context.Context, url string) (Repository, error) {
 	var repository Repository
+	err := mg.run(ctx, "find_repo", func(db *mgo.Database) error {
+		err := db.C("repository").Find(bson.M{"url": url}).One(&repository)
+		if err == mgo.ErrNotFound {
+			return ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return Repository{}, err
+	}
+	upgrade(&repository)
+	return repository, nil
+}
 
-	c := session.DB(mg.dbName).C("repository")
-	err := c.Find(bson.M{"url": url}).One(&repository)
+//Each iterates over the repos with a URL prefix
+func (mg *mongo) Each(ctx context.Context, prefix string, f func(repository Repository) error) error {
+	return mg.run(ctx, "each", func(db *mgo.Database) error {
+		query := bson.M{"url": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix)}}
+		iter := db.C("repository").Find(query).Sort("url").Iter()
 
-	return repository, err
+		repository := Repository{}
+		for iter.Next(&repository) {
+			//The iteration stops once ctx is done
+			if err := ctx.Err(); err != nil {
+				iter.Close()
+				return err
+			}
+			upgrade(&repository)
+			if err := f(repository); err != nil {
+				iter.Close()
+				return err
+			}
+			repository = Repository{}
+		}
+		return iter.Close()
+	})
 }
 
-//FindAll retuns all the repo
-func (mg *mongo) FindAll(skip int, limit int) ([]Repository, error) {
-	session := mg.session.Copy()
-	defer session.Close()
+//TouchRepo records when a repo was requested
+func (mg *mongo) TouchRepo(ctx context.Context, url string, requested int64) error {
+	return mg.run(ctx, "touch_repo", func(db *mgo.Database) error {
+		err := db.C("repository").Update(bson.M{"url": url}, bson.M{"$set": bson.M{"requested": requested}})
+		if err == mgo.ErrNotFound {
+			return ErrNotFound
+		}
+		return err
+	})
+}
+
+//DeleteAnalyses deletes analyses of a repo by commit
+func (mg *mongo) DeleteAnalyses(ctx context.Context, url string, hashes []string, revision Revision) error {
+	return mg.run(ctx, "delete_analyses", func(db *mgo.Database) error {
+		c := db.C("repository")
+		err := c.Update(
+			atRevision(url, revision),
+			bson.M{"$pull": bson.M{"analysis": bson.M{"hash": bson.M{"$in": hashes}}}},
+		)
+		if err == mgo.ErrNotFound {
+			return notFoundOrChanged(c, url)
+		}
+		return err
+	})
+}
 
+//DeleteRepo deletes a repo
+func (mg *mongo) DeleteRepo(ctx context.Context, url string, revision Revision) error {
+	return mg.run(ctx, "delete_repo", func(db *mgo.Database) error {
+		c := db.C("repository")
+		err := c.Remove(atRevision(url, revision))
+		if err == mgo.ErrNotFound {
+			return notFoundOrChanged(c, url)
+		}
+		return err
+	})
+}
+
+//atRevision selects the repository at url if it is at revision
+func atRevision(url string, revision Revision) bson.M {
+	selector := bson.M{"url": url, "requested": revision.Requested}
+	if revision.Requested == 0 {
+		//The repositories stored before the requests were tracked lack it
+		selector["requested"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	if revision.Analyses == 0 {
+		selector["analysis.0"] = bson.M{"$exists": false}
+	} else {
+		selector["analysis"] = bson.M{"$size": revision.Analyses}
+		selector[fmt.Sprintf("analysis.%d.hash", revision.Analyses-1)] = revision.Latest
+	}
+	return selector
+}
+
+//notFoundOrChanged tells why no repository was selected at a revision of
+//url
+func notFoundOrChanged(c *mgo.Collection, url string) error {
+	n, err := c.Find(bson.M{"url": url}).Count()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return ErrChanged
+}
+
+//FindAll retuns all the repo
+func (mg *mongo) FindAll(ctx context.Context, language string, skip int, limit int, sort string) ([]Repository, error) {
 	repositories := []Repository{}
+	err := mg.run(ctx, "find_all", func(db *mgo.Database) error {
+		selector := bson.M{}
+		if language != "" {
+			selector["language"] = language
+		}
+		query := db.C("repository").Find(selector)
+		if sort != "" {
+			query = query.Sort(sort)
+		}
+		return query.Skip(skip).Limit(limit).All(&repositories)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range repositories {
+		upgrade(&repositories[i])
+	}
+	return repositories, nil
+}
 
-	c := session.DB(mg.dbName).C("repository")
+//outdated selects the repositories of older schema versions, including the
+//ones written before the version was
+func outdated() bson.M {
+	return bson.M{"schema_version": bson.M{"$not": bson.M{"$gte": SchemaVersion}}}
+}
+
+//MigrateDocuments replaces the repositories of older schema versions by
+//their upgrade. The repositories changed meanwhile are left to the next run.
+func (mg *mongo) MigrateDocuments(ctx context.Context) (int, error) {
+	count := 0
+	err := mg.run(ctx, "migrate_documents", func(db *mgo.Database) error {
+		c := db.C("repository")
+		iter := c.Find(outdated()).Iter()
+
+		repository := Repository{}
+		for iter.Next(&repository) {
+			if err := ctx.Err(); err != nil {
+				iter.Close()
+				return err
+			}
+
+			//The repository is replaced only if it wasn't written since it
+			//was read
+			selector := atRevision(repository.URL, repository.Revision())
+			selector["schema_version"] = outdated()["schema_version"]
+			selector["language"] = repository.Language
+			if repository.Language == "" {
+				selector["language"] = bson.M{"$in": []interface{}{"", nil}}
+			}
 
-	err := c.Find(bson.M{}).Skip(skip).Limit(limit).All(&repositories)
-	return repositories, err
+			upgrade(&repository)
+			if repository.Analysis == nil {
+				repository.Analysis = []*Analysis{}
+			}
+			err := c.Update(selector, repository)
+			if err == nil {
+				count++
+			} else if err != mgo.ErrNotFound {
+				iter.Close()
+				return err
+			}
+			repository = Repository{}
+		}
+		return iter.Close()
+	})
+	return count, err
 }
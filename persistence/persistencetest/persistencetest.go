@@ -0,0 +1,795 @@
+//Package persistencetest checks that the implementations of
+//persistence.Store behave the same
+package persistencetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//Run runs the conformance tests against the stores returned by newStore.
+//Each test gets an empty store, closed once it completes.
+func Run(t *testing.T, newStore func(t *testing.T) persistence.Store) {
+
+	testCases := []struct {
+		name string
+		test func(t *testing.T, store persistence.Store)
+	}{
+		{"Ping", testPing},
+		{"AppendAnalysis", testAppendAnalysis},
+		{"AppendAnalysisIdempotent", testAppendAnalysisIdempotent},
+		{"AppendAnalysisConcurrently", testAppendAnalysisConcurrently},
+		{"FindRepoNotFound", testFindRepoNotFound},
+		{"FindRepoCopies", testFindRepoCopies},
+		{"FindAll", testFindAll},
+		{"Each", testEach},
+		{"ExportImport", testExportImport},
+		{"TouchRepo", testTouchRepo},
+		{"DeleteAnalyses", testDeleteAnalyses},
+		{"DeleteRepo", testDeleteRepo},
+		{"Prune", testPrune},
+		{"EnqueueJob", testEnqueueJob},
+		{"EnqueueJobConcurrently", testEnqueueJobConcurrently},
+		{"ClaimJob", testClaimJob},
+		{"ClaimExpiredJob", testClaimExpiredJob},
+		{"CompleteJob", testCompleteJob},
+		{"ReleaseJob", testReleaseJob},
+		{"LeaseLost", testLeaseLost},
+		{"Canceled", testCanceled},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			store := newStore(t)
+			defer store.Close()
+			testCase.test(t, store)
+		})
+	}
+}
+
+//ctx is the context of the operations of the tests
+var ctx = context.Background()
+
+//analysis returns a done analysis of commit hash with one leak
+func analysis(hash string, score float64) *persistence.Analysis {
+	return &persistence.Analysis{
+		Hash:   hash,
+		Status: persistence.StatusDone,
+		Score:  score,
+		Grade:  hash,
+		Leaks: []*persistence.Leak{
+			{File: "a.go", Line: 1, Kind: persistence.LeakGlobal, Configurations: []string{"linux/amd64", "windows/amd64 appengine"}},
+		},
+	}
+}
+
+//appendAnalyses appends the analyses of hashes to the repository at url
+func appendAnalyses(t *testing.T, store persistence.Store, url string, score float64, hashes ...string) {
+	for _, hash := range hashes {
+		if _, err := store.AppendAnalysis(ctx, url, "GO", analysis(hash, score)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//hashes returns the hashes of the analyses of the repository at url
+func hashes(t *testing.T, store persistence.Store, url string) []string {
+	repository, err := store.FindRepo(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := []string{}
+	for _, analysis := range repository.Analysis {
+		hashes = append(hashes, analysis.Hash)
+	}
+	return hashes
+}
+
+//revision returns the current revision of the repository at url
+func revision(t *testing.T, store persistence.Store, url string) persistence.Revision {
+	repository, err := store.FindRepo(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repository.Revision()
+}
+
+func testPing(t *testing.T, store persistence.Store) {
+	if err := store.Ping(ctx); err != nil {
+		t.Errorf("expected no error; got %v", err)
+	}
+}
+
+func testAppendAnalysis(t *testing.T, store persistence.Store) {
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "a")
+
+	found, err := store.FindRepo(ctx, "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.URL != "github.com/depbleed/go" || found.Language != "GO" || found.Score != 1 || found.Grade != "a" {
+		t.Errorf("expected the repository to be created with the analysis score; got %+v", found)
+	}
+	if len(found.Analysis) != 1 || found.Latest().Hash != "a" || len(found.Latest().Leaks) != 1 || found.Latest().Leaks[0].File != "a.go" {
+		t.Errorf("expected the analysis with its leak; got %+v", found.Analysis)
+	} else if configurations := found.Latest().Leaks[0].Configurations; len(configurations) != 2 || configurations[1] != "windows/amd64 appengine" {
+		t.Errorf("expected the configurations of the leak; got %v", configurations)
+	}
+
+	appendAnalyses(t, store, "github.com/depbleed/go", 2, "b")
+	found, err = store.FindRepo(ctx, "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Score != 2 || found.Grade != "b" || found.Latest().Hash != "b" {
+		t.Errorf("expected the latest analysis to be b; got %+v", found)
+	}
+
+	//A repository stored without a language takes the next one
+	if _, err := store.AppendAnalysis(ctx, "github.com/depbleed/none", "", analysis("a", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if inserted, err := store.AppendAnalysis(ctx, "github.com/depbleed/none", "GO", analysis("a", 1)); err != nil || inserted {
+		t.Fatalf("expected the stored analysis not to be inserted again; got %v, %v", inserted, err)
+	}
+	found, err = store.FindRepo(ctx, "github.com/depbleed/none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Language != "GO" || len(found.Analysis) != 1 {
+		t.Errorf("expected the language to be filled; got %+v", found)
+	}
+}
+
+func testAppendAnalysisIdempotent(t *testing.T, store persistence.Store) {
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "a", "b")
+	appendAnalyses(t, store, "github.com/depbleed/go", 3, "a")
+
+	found, err := store.FindRepo(ctx, "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Analysis) != 2 || found.Latest().Hash != "b" {
+		t.Errorf("expected the analyses a and b; got %v", hashes(t, store, "github.com/depbleed/go"))
+	}
+	if found.Score != 1 {
+		t.Errorf("expected the score of b to be kept; got %v", found.Score)
+	}
+}
+
+func testAppendAnalysisConcurrently(t *testing.T, store persistence.Store) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	inserted := make(chan bool, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			//Every analysis is appended twice
+			hash := fmt.Sprintf("%d", i%10)
+			ok, err := store.AppendAnalysis(ctx, "github.com/depbleed/go", "GO", analysis(hash, 1))
+			errs <- err
+			inserted <- ok
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	close(inserted)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := 0
+	for ok := range inserted {
+		if ok {
+			count++
+		}
+	}
+	if count != 10 {
+		t.Errorf("expected 10 analyses reported inserted; got %d", count)
+	}
+
+	found := hashes(t, store, "github.com/depbleed/go")
+	seen := map[string]bool{}
+	for _, hash := range found {
+		if seen[hash] {
+			t.Errorf("expected no duplicate analysis; got %v", found)
+		}
+		seen[hash] = true
+	}
+	if len(seen) != 10 {
+		t.Errorf("expected 10 analyses; got %v", found)
+	}
+}
+
+func testFindRepoNotFound(t *testing.T, store persistence.Store) {
+	if _, err := store.FindRepo(ctx, "github.com/depbleed/go"); err != persistence.ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+}
+
+func testFindRepoCopies(t *testing.T, store persistence.Store) {
+	appended := analysis("a", 1)
+	if _, err := store.AppendAnalysis(ctx, "github.com/depbleed/go", "GO", appended); err != nil {
+		t.Fatal(err)
+	}
+	appended.Leaks[0].File = "changed"
+	appended.Leaks[0].Configurations[0] = "changed"
+
+	found, err := store.FindRepo(ctx, "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found.Analysis[0].Leaks[0].File = "changed"
+	found.Analysis[0].Leaks[0].Configurations[0] = "changed"
+
+	found, err = store.FindRepo(ctx, "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Analysis[0].Leaks[0].File != "a.go" || found.Analysis[0].Leaks[0].Configurations[0] != "linux/amd64" {
+		t.Errorf("expected the stored repository to be unchanged; got %+v", found.Analysis[0].Leaks[0])
+	}
+}
+
+func testFindAll(t *testing.T, store persistence.Store) {
+	appendAnalyses(t, store, "github.com/b", 2, "b")
+	appendAnalyses(t, store, "github.com/c", 3, "c")
+	appendAnalyses(t, store, "github.com/a", 1, "a")
+	if _, err := store.AppendAnalysis(ctx, "github.com/d", "PYTHON", analysis("d", 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		language string
+		skip     int
+		limit    int
+		sort     string
+		expected []string
+	}{
+		{"GO", 0, 10, "url", []string{"github.com/a", "github.com/b", "github.com/c"}},
+		{"GO", 0, 10, "-score", []string{"github.com/c", "github.com/b", "github.com/a"}},
+		{"GO", 1, 1, "score", []string{"github.com/b"}},
+		{"GO", 0, 2, "-url", []string{"github.com/c", "github.com/b"}},
+		{"GO", 5, 10, "url", []string{}},
+		{"PYTHON", 0, 10, "url", []string{"github.com/d"}},
+		{"RUST", 0, 10, "url", []string{}},
+		{"", 0, 10, "-score", []string{"github.com/d", "github.com/c", "github.com/b", "github.com/a"}},
+	}
+
+	for _, testCase := range testCases {
+		repositories, err := store.FindAll(ctx, testCase.language, testCase.skip, testCase.limit, testCase.sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		urls := []string{}
+		for _, r := range repositories {
+			urls = append(urls, r.URL)
+		}
+		if len(urls) != len(testCase.expected) {
+			t.Errorf("FindAll(%q, %d, %d, %q): expected %v; got %v", testCase.language, testCase.skip, testCase.limit, testCase.sort, testCase.expected, urls)
+			continue
+		}
+		for i := range urls {
+			if urls[i] != testCase.expected[i] {
+				t.Errorf("FindAll(%q, %d, %d, %q): expected %v; got %v", testCase.language, testCase.skip, testCase.limit, testCase.sort, testCase.expected, urls)
+				break
+			}
+		}
+	}
+
+	repositories, err := store.FindAll(ctx, "GO", 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repositories) != 3 {
+		t.Errorf("expected all the repositories without limit; got %d", len(repositories))
+	}
+}
+
+func testEach(t *testing.T, store persistence.Store) {
+	//More repositories than the stores read at once
+	expected := []string{}
+	for i := 0; i < 120; i++ {
+		url := fmt.Sprintf("github.com/depbleed/%03d", i)
+		appendAnalyses(t, store, url, 1, "a")
+		expected = append(expected, url)
+	}
+	appendAnalyses(t, store, "github.com/other/go", 1, "a")
+	appendAnalyses(t, store, "github.com/depbleed_go", 1, "a")
+
+	urls := []string{}
+	err := store.Each(ctx, "github.com/depbleed/", func(repository persistence.Repository) error {
+		if len(repository.Analysis) != 1 {
+			t.Errorf("expected the analyses of %s; got %+v", repository.URL, repository.Analysis)
+		}
+		urls = append(urls, repository.URL)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(urls) != fmt.Sprint(expected) {
+		t.Errorf("expected the repositories with the prefix by URL; got %v", urls)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.Each(ctx, "", func(repository persistence.Repository) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("expected the iteration to stop at the first error; got %v after %d calls", err, calls)
+	}
+}
+
+func testExportImport(t *testing.T, store persistence.Store) {
+	for i, hash := range []string{"a", "b", "c"} {
+		analysis := analysis(hash, float64(i))
+		analysis.Time = time.Date(2018, time.Month(i+1), 1, 0, 0, 0, 0, time.UTC).Unix()
+		if _, err := store.AppendAnalysis(ctx, "github.com/depbleed/go", "GO", analysis); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendAnalyses(t, store, "github.com/other/go", 1, "d")
+	if err := store.TouchRepo(ctx, "github.com/depbleed/go", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	var all bytes.Buffer
+	if count, err := persistence.Export(ctx, store, &all, persistence.Filter{}); err != nil || count != 4 {
+		t.Fatalf("expected 4 records; got %d, %v", count, err)
+	}
+	if lines := strings.Count(all.String(), "\n"); lines != 4 {
+		t.Errorf("expected a record per line; got %s", all.String())
+	}
+
+	var filtered bytes.Buffer
+	filter := persistence.Filter{
+		Prefix: "github.com/depbleed/",
+		Since:  time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if count, err := persistence.Export(ctx, store, &filtered, filter); err != nil || count != 2 {
+		t.Fatalf("expected 2 records; got %d, %v", count, err)
+	}
+
+	imported := persistence.NewMemory()
+	if count, err := persistence.Import(ctx, imported, &filtered, persistence.Filter{}); err != nil || count != 2 {
+		t.Fatalf("expected 2 records imported; got %d, %v", count, err)
+	}
+	found, err := imported.FindRepo(ctx, "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Language != "GO" || found.Requested != 10 || found.Grade != "c" || len(found.Analysis) != 2 || len(found.Analysis[1].Leaks) != 1 {
+		t.Errorf("expected the analyses b and c with their leaks; got %+v", found)
+	}
+
+	//Importing the export again changes nothing
+	if count, err := persistence.Import(ctx, store, bytes.NewReader(all.Bytes()), persistence.Filter{Until: time.Now()}); err != nil || count != 0 {
+		t.Fatalf("expected no record imported; got %d, %v", count, err)
+	}
+	if found := hashes(t, store, "github.com/depbleed/go"); fmt.Sprint(found) != "[a b c]" {
+		t.Errorf("expected the analyses a, b and c; got %v", found)
+	}
+
+	//The older analyses are imported before the newer ones, which keep the
+	//score
+	if err := store.DeleteAnalyses(ctx, "github.com/depbleed/go", []string{"a", "b"}, revision(t, store, "github.com/depbleed/go")); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := persistence.Import(ctx, store, bytes.NewReader(all.Bytes()), persistence.Filter{}); err != nil || count != 2 {
+		t.Fatalf("expected the 2 deleted records imported; got %d, %v", count, err)
+	}
+	found, err = store.FindRepo(ctx, "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Grade != "c" || len(found.Analysis) != 3 || found.Analysis[0].Hash != "a" || found.Analysis[1].Hash != "b" || found.Latest().Hash != "c" {
+		t.Errorf("expected the analyses a and b before c, and the grade of c; got %+v", found)
+	}
+
+	if _, err := persistence.Import(ctx, store, strings.NewReader(`{"url": "github.com/depbleed/go"}`), persistence.Filter{}); err == nil {
+		t.Errorf("expected an error for a record without analysis")
+	}
+}
+
+func testTouchRepo(t *testing.T, store persistence.Store) {
+	if err := store.TouchRepo(ctx, "github.com/depbleed/go", 10); err != persistence.ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "a")
+	if err := store.TouchRepo(ctx, "github.com/depbleed/go", 10); err != nil {
+		t.Fatal(err)
+	}
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "b")
+
+	found, err := store.FindRepo(ctx, "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Requested != 10 {
+		t.Errorf("expected the request time to be kept; got %d", found.Requested)
+	}
+}
+
+func testDeleteAnalyses(t *testing.T, store persistence.Store) {
+	if err := store.DeleteAnalyses(ctx, "github.com/depbleed/go", []string{"a"}, persistence.Revision{}); err != persistence.ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "a", "b", "c")
+	stale := revision(t, store, "github.com/depbleed/go")
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "d")
+
+	//Nothing is deleted from a repository changed since it was read
+	if err := store.DeleteAnalyses(ctx, "github.com/depbleed/go", []string{"a"}, stale); err != persistence.ErrChanged {
+		t.Errorf("expected ErrChanged; got %v", err)
+	}
+	if found := hashes(t, store, "github.com/depbleed/go"); fmt.Sprint(found) != "[a b c d]" {
+		t.Errorf("expected the analyses to be kept; got %v", found)
+	}
+
+	current := revision(t, store, "github.com/depbleed/go")
+	if err := store.DeleteAnalyses(ctx, "github.com/depbleed/go", []string{"a", "c", "unknown"}, current); err != nil {
+		t.Fatal(err)
+	}
+	if found := hashes(t, store, "github.com/depbleed/go"); fmt.Sprint(found) != "[b d]" {
+		t.Errorf("expected the analyses b and d; got %v", found)
+	}
+
+	//The positions of the deleted analyses don't get in the way
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "e")
+	if found := hashes(t, store, "github.com/depbleed/go"); fmt.Sprint(found) != "[b d e]" {
+		t.Errorf("expected the analyses b, d and e; got %v", found)
+	}
+}
+
+func testDeleteRepo(t *testing.T, store persistence.Store) {
+	if err := store.DeleteRepo(ctx, "github.com/depbleed/go", persistence.Revision{}); err != persistence.ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "a")
+	appendAnalyses(t, store, "github.com/depbleed/backend", 1, "a")
+
+	//A repository requested since it was read is kept
+	stale := revision(t, store, "github.com/depbleed/go")
+	if err := store.TouchRepo(ctx, "github.com/depbleed/go", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteRepo(ctx, "github.com/depbleed/go", stale); err != persistence.ErrChanged {
+		t.Errorf("expected ErrChanged; got %v", err)
+	}
+
+	if err := store.DeleteRepo(ctx, "github.com/depbleed/go", revision(t, store, "github.com/depbleed/go")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.FindRepo(ctx, "github.com/depbleed/go"); err != persistence.ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+	repositories, err := store.FindAll(ctx, "", 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repositories) != 1 || repositories[0].URL != "github.com/depbleed/backend" {
+		t.Errorf("expected only the other repository; got %+v", repositories)
+	}
+
+	//The repository can be analyzed again
+	appendAnalyses(t, store, "github.com/depbleed/go", 1, "a")
+	if found := hashes(t, store, "github.com/depbleed/go"); fmt.Sprint(found) != "[a]" {
+		t.Errorf("expected the analysis a; got %v", found)
+	}
+}
+
+func testPrune(t *testing.T, store persistence.Store) {
+	now := time.Date(2018, 6, 15, 0, 0, 0, 0, time.UTC)
+	month := func(months int) int64 {
+		return now.AddDate(0, months, 0).Unix()
+	}
+
+	//Two analyses a month for six months
+	for i, analysed := range []int64{month(-6), month(-6) + 1, month(-5), month(-5) + 1, month(-1), month(-1) + 1, now.Unix()} {
+		analysis := analysis(fmt.Sprint(i), 1)
+		analysis.Time = analysed
+		if _, err := store.AppendAnalysis(ctx, "github.com/depbleed/go", "GO", analysis); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := analysis("old", 1)
+	old.Time = month(-13)
+	if _, err := store.AppendAnalysis(ctx, "github.com/depbleed/old", "GO", old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AppendAnalysis(ctx, "github.com/depbleed/requested", "GO", old); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.TouchRepo(ctx, "github.com/depbleed/requested", month(-1)); err != nil {
+		t.Fatal(err)
+	}
+
+	retention := persistence.Retention{Analyses: 2, Unrequested: 365 * 24 * time.Hour}
+	for _, dryRun := range []bool{true, false} {
+		prunings, err := persistence.Prune(ctx, store, retention, now, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%+v", prunings) != "[{URL:github.com/depbleed/go Repository:false Hashes:[0 2 4] revision:{Requested:0 Analyses:7 Latest:6}} {URL:github.com/depbleed/old Repository:true Hashes:[] revision:{Requested:0 Analyses:1 Latest:old}}]" {
+			t.Errorf("expected the older analyses of each month and the unrequested repository; got %+v", prunings)
+		}
+	}
+
+	if found := hashes(t, store, "github.com/depbleed/go"); fmt.Sprint(found) != "[1 3 5 6]" {
+		t.Errorf("expected the latest analysis of each month; got %v", found)
+	}
+	if _, err := store.FindRepo(ctx, "github.com/depbleed/old"); err != persistence.ErrNotFound {
+		t.Errorf("expected the unrequested repository to be deleted; got %v", err)
+	}
+	if found := hashes(t, store, "github.com/depbleed/requested"); fmt.Sprint(found) != "[old]" {
+		t.Errorf("expected the requested repository to be kept; got %v", found)
+	}
+
+	prunings, err := persistence.Prune(ctx, store, retention, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prunings) != 0 {
+		t.Errorf("expected nothing left to prune; got %+v", prunings)
+	}
+
+	//A repository requested while pruning is kept
+	if _, err := store.AppendAnalysis(ctx, "github.com/depbleed/old", "GO", old); err != nil {
+		t.Fatal(err)
+	}
+	prunings, err = persistence.Prune(ctx, touching{store, "github.com/depbleed/old", now.Unix()}, retention, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prunings) != 0 {
+		t.Errorf("expected the repository requested meanwhile to be skipped; got %+v", prunings)
+	}
+	if _, err := store.FindRepo(ctx, "github.com/depbleed/old"); err != nil {
+		t.Errorf("expected the repository requested meanwhile to be kept; got %v", err)
+	}
+}
+
+//touching is a store where the repository at url is requested at
+//requested once iterated over
+type touching struct {
+	persistence.Store
+	url       string
+	requested int64
+}
+
+func (s touching) Each(ctx context.Context, prefix string, f func(repository persistence.Repository) error) error {
+	if err := s.Store.Each(ctx, prefix, f); err != nil {
+		return err
+	}
+	return s.Store.TouchRepo(ctx, s.url, s.requested)
+}
+
+//count returns the number of jobs with status
+func count(t *testing.T, store persistence.Store, status string) int {
+	n, err := store.CountJobs(ctx, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func testEnqueueJob(t *testing.T, store persistence.Store) {
+	for _, commit := range []string{"a", "a", "b"} {
+		if err := store.EnqueueJob(ctx, "depbleed/go", "GO", commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := count(t, store, persistence.JobQueued); n != 2 {
+		t.Errorf("expected 2 queued jobs; got %d", n)
+	}
+}
+
+func testEnqueueJobConcurrently(t *testing.T, store persistence.Store) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			//Every job is queued twice
+			errs <- store.EnqueueJob(ctx, "depbleed/go", "GO", fmt.Sprintf("%d", i%10))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(t, store, persistence.JobQueued); n != 10 {
+		t.Errorf("expected 10 queued jobs; got %d", n)
+	}
+}
+
+func testClaimJob(t *testing.T, store persistence.Store) {
+	if _, err := store.ClaimJob(ctx, "worker", time.Minute); err != persistence.ErrNoJob {
+		t.Errorf("expected ErrNoJob; got %v", err)
+	}
+
+	for _, commit := range []string{"a", "b"} {
+		if err := store.EnqueueJob(ctx, "depbleed/go", "GO", commit); err != nil {
+			t.Fatal(err)
+		}
+		//The jobs are claimed in the order they are created
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	job, err := store.ClaimJob(ctx, "worker", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Commit != "a" || job.Language != "GO" || job.Status != persistence.JobRunning || job.Worker != "worker" || job.Attempts != 1 {
+		t.Errorf("expected the oldest job to be running for worker; got %+v", job)
+	}
+	if n := count(t, store, persistence.JobRunning); n != 1 {
+		t.Errorf("expected 1 running job; got %d", n)
+	}
+	if err := store.RenewJob(ctx, job, time.Minute); err != nil {
+		t.Errorf("expected the lease to be renewed; got %v", err)
+	}
+
+	//A running job isn't queued again
+	if err := store.EnqueueJob(ctx, "depbleed/go", "GO", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, store, persistence.JobQueued); n != 1 {
+		t.Errorf("expected 1 queued job; got %d", n)
+	}
+}
+
+func testClaimExpiredJob(t *testing.T, store persistence.Store) {
+	if err := store.EnqueueJob(ctx, "depbleed/go", "GO", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ClaimJob(ctx, "first", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := store.ClaimJob(ctx, "second", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Worker != "second" || job.Attempts != 2 {
+		t.Errorf("expected the expired job to be claimed again; got %+v", job)
+	}
+}
+
+func testCompleteJob(t *testing.T, store persistence.Store) {
+	for _, commit := range []string{"a", "b"} {
+		if err := store.EnqueueJob(ctx, "depbleed/go", "GO", commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done, err := store.ClaimJob(ctx, "worker", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CompleteJob(ctx, done, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := store.ClaimJob(ctx, "worker", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CompleteJob(ctx, failed, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if failed.Status != persistence.JobFailed || failed.Error != "boom" {
+		t.Errorf("expected the job to fail with boom; got %+v", failed)
+	}
+
+	if n := count(t, store, persistence.JobDone); n != 1 {
+		t.Errorf("expected 1 done job; got %d", n)
+	}
+	if n := count(t, store, persistence.JobFailed); n != 1 {
+		t.Errorf("expected 1 failed job; got %d", n)
+	}
+
+	//Failed jobs are queued again, done ones aren't
+	for _, job := range []*persistence.Job{done, failed} {
+		if err := store.EnqueueJob(ctx, job.Repo, job.Language, job.Commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(t, store, persistence.JobQueued); n != 1 {
+		t.Errorf("expected the failed job to be queued again; got %d queued", n)
+	}
+}
+
+func testReleaseJob(t *testing.T, store persistence.Store) {
+	if err := store.EnqueueJob(ctx, "depbleed/go", "GO", "a"); err != nil {
+		t.Fatal(err)
+	}
+	job, err := store.ClaimJob(ctx, "worker", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ReleaseJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != persistence.JobQueued {
+		t.Errorf("expected the job to be queued; got %s", job.Status)
+	}
+
+	job, err = store.ClaimJob(ctx, "worker", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Attempts != 1 {
+		t.Errorf("expected the released attempt not to count; got %d attempts", job.Attempts)
+	}
+}
+
+func testLeaseLost(t *testing.T, store persistence.Store) {
+	if err := store.EnqueueJob(ctx, "depbleed/go", "GO", "a"); err != nil {
+		t.Fatal(err)
+	}
+	lost, err := store.ClaimJob(ctx, "first", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ClaimJob(ctx, "second", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RenewJob(ctx, lost, time.Minute); err != persistence.ErrLeaseLost {
+		t.Errorf("RenewJob: expected ErrLeaseLost; got %v", err)
+	}
+	if err := store.CompleteJob(ctx, lost, nil); err != persistence.ErrLeaseLost {
+		t.Errorf("CompleteJob: expected ErrLeaseLost; got %v", err)
+	}
+	if err := store.ReleaseJob(ctx, lost); err != persistence.ErrLeaseLost {
+		t.Errorf("ReleaseJob: expected ErrLeaseLost; got %v", err)
+	}
+	if n := count(t, store, persistence.JobRunning); n != 1 {
+		t.Errorf("expected the job to keep running for second; got %d running", n)
+	}
+}
+
+func testCanceled(t *testing.T, store persistence.Store) {
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := store.FindRepo(canceled, "github.com/depbleed/go"); err != context.Canceled {
+		t.Errorf("FindRepo: expected context.Canceled; got %v", err)
+	}
+	if _, err := store.AppendAnalysis(canceled, "github.com/depbleed/go", "GO", analysis("a", 1)); err != context.Canceled {
+		t.Errorf("AppendAnalysis: expected context.Canceled; got %v", err)
+	}
+	if err := store.EnqueueJob(canceled, "depbleed/go", "GO", "a"); err != context.Canceled {
+		t.Errorf("EnqueueJob: expected context.Canceled; got %v", err)
+	}
+
+	if _, err := store.FindRepo(ctx, "github.com/depbleed/go"); err != persistence.ErrNotFound {
+		t.Errorf("expected the canceled insert not to be stored; got %v", err)
+	}
+}
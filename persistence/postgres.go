@@ -0,0 +1,662 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//postgresDuration is the latency of the PostgreSQL operations
+var postgresDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "depbleed_postgres_operation_duration_seconds",
+	Help: "Latency of the PostgreSQL operations.",
+}, []string{"operation"})
+
+func init() {
+	prometheus.MustRegister(postgresDuration)
+}
+
+//migrations are the changes of the schema, applied once each in order.
+//Released migrations must not be modified; new ones are appended.
+var migrations = []string{
+	`CREATE TABLE repository (
+		url text PRIMARY KEY,
+		language text NOT NULL,
+		score double precision NOT NULL,
+		grade text NOT NULL
+	);
+	CREATE INDEX repository_score ON repository (score);
+
+	CREATE TABLE analysis (
+		id bigserial PRIMARY KEY,
+		repository text NOT NULL REFERENCES repository (url) ON DELETE CASCADE,
+		position integer NOT NULL,
+		hash text NOT NULL,
+		"time" bigint NOT NULL,
+		status text NOT NULL,
+		reason text NOT NULL,
+		exported integer NOT NULL,
+		score double precision NOT NULL,
+		grade text NOT NULL,
+		UNIQUE (repository, position)
+	);
+	CREATE INDEX analysis_hash ON analysis (repository, hash);
+
+	CREATE TABLE leak (
+		analysis bigint NOT NULL REFERENCES analysis (id) ON DELETE CASCADE,
+		position integer NOT NULL,
+		file text NOT NULL,
+		line integer NOT NULL,
+		"column" integer NOT NULL,
+		message text NOT NULL,
+		kind text NOT NULL,
+		PRIMARY KEY (analysis, position)
+	)`,
+
+	`CREATE TABLE job (
+		id text PRIMARY KEY,
+		repo text NOT NULL,
+		"commit" text NOT NULL,
+		status text NOT NULL,
+		worker text NOT NULL DEFAULT '',
+		lease timestamptz,
+		attempts integer NOT NULL DEFAULT 0,
+		created timestamptz NOT NULL,
+		error text NOT NULL DEFAULT '',
+		UNIQUE (repo, "commit")
+	);
+	CREATE INDEX job_status ON job (status, created)`,
+
+	`DELETE FROM analysis WHERE EXISTS (
+		SELECT 1 FROM analysis first
+		WHERE first.repository = analysis.repository AND first.hash = analysis.hash
+		AND first.position < analysis.position
+	);
+	DROP INDEX analysis_hash;
+	CREATE UNIQUE INDEX analysis_hash ON analysis (repository, hash)`,
+
+	`ALTER TABLE repository ADD COLUMN requested bigint NOT NULL DEFAULT 0`,
+
+	`ALTER TABLE job ADD COLUMN language text NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE leak ADD COLUMN configurations text[]`,
+}
+
+//PostgresConfig tells how to connect to PostgreSQL. URL is a postgres://
+//connection string or key=value settings.
+type PostgresConfig struct {
+	URL string `json:"url"`
+}
+
+//passwordSetting matches the password of key=value settings
+var passwordSetting = regexp.MustCompile(`password\s*=\s*('(\\.|[^'\\])*'|\S+)`)
+
+//isURL tells whether the configuration is a URL rather than key=value
+//settings
+func (c PostgresConfig) isURL() bool {
+	return strings.HasPrefix(c.URL, "postgres://") || strings.HasPrefix(c.URL, "postgresql://")
+}
+
+//Validate checks the configuration without connecting
+func (c PostgresConfig) Validate() error {
+	if c.isURL() {
+		_, err := pq.ParseURL(c.URL)
+		return err
+	}
+	if !strings.Contains(c.URL, "=") {
+		return errors.New("expected a postgres:// URL or key=value settings")
+	}
+	return nil
+}
+
+//Redacted returns the configuration without the password, for printing
+func (c PostgresConfig) Redacted() PostgresConfig {
+	if !c.isURL() {
+		c.URL = passwordSetting.ReplaceAllString(c.URL, "password=REDACTED")
+		return c
+	}
+
+	parsed, err := url.Parse(c.URL)
+	if err != nil {
+		c.URL = "REDACTED"
+		return c
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), "REDACTED")
+		c.URL = parsed.String()
+	}
+	return c
+}
+
+//postgres is a DAO implementation for PostgreSQL. The repositories, their
+//analyses and their leaks are kept in their own tables.
+type postgres struct {
+	db *sql.DB
+}
+
+//NewPostgres returns a Store keeping everything in PostgreSQL. The schema
+//is migrated to the latest version.
+func NewPostgres(config PostgresConfig) (*postgres, error) {
+	db, err := sql.Open("postgres", config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &postgres{db: db}, nil
+}
+
+//migrate applies the migrations that weren't yet
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migration (
+		version integer PRIMARY KEY,
+		applied timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	for i, migration := range migrations {
+		version := i + 1
+		err := transaction(context.Background(), db, func(tx *sql.Tx) error {
+			//The processes starting at once apply each migration in turn
+			if _, err := tx.Exec(`LOCK TABLE schema_migration IN EXCLUSIVE MODE`); err != nil {
+				return err
+			}
+
+			var applied bool
+			err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migration WHERE version = $1)`, version).Scan(&applied)
+			if err != nil || applied {
+				return err
+			}
+
+			if _, err := tx.Exec(migration); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO schema_migration (version) VALUES ($1)`, version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("can't apply migration %d: %v", version, err)
+		}
+	}
+	return nil
+}
+
+//transaction runs f in a transaction, committed unless f fails. It is
+//rolled back if ctx is done first.
+func transaction(ctx context.Context, db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//observe records the latency of operation, started at start
+func (pg *postgres) observe(operation string, start time.Time) {
+	postgresDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+//Ping checks that the database answers
+func (pg *postgres) Ping(ctx context.Context) error {
+	defer pg.observe("ping", time.Now())
+	return pg.db.PingContext(ctx)
+}
+
+//Close closes the connections; the DAO can't be used afterwards
+func (pg *postgres) Close() {
+	pg.db.Close()
+}
+
+//AppendAnalysis appends analysis to the repository at url, created with
+//language if needed or given it if it has none, unless an analysis of the
+//same commit is stored. The repository row is locked so that concurrent
+//appends apply in turn. It reports whether analysis was inserted.
+func (pg *postgres) AppendAnalysis(ctx context.Context, url string, language string, analysis *Analysis) (bool, error) {
+	defer pg.observe("append_analysis", time.Now())
+
+	inserted := false
+	err := transaction(ctx, pg.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO repository (url, language, score, grade) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (url) DO NOTHING`,
+			url, language, analysis.Score, analysis.Grade,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM repository WHERE url = $1 FOR UPDATE`, url); err != nil {
+			return err
+		}
+		if language != "" {
+			_, err := tx.ExecContext(ctx, `UPDATE repository SET language = $2 WHERE url = $1 AND language = ''`, url, language)
+			if err != nil {
+				return err
+			}
+		}
+
+		var exists bool
+		var position int
+		err = tx.QueryRowContext(ctx,
+			`SELECT coalesce(bool_or(hash = $2), false), coalesce(max(position) + 1, 0)
+			FROM analysis WHERE repository = $1`,
+			url, analysis.Hash,
+		).Scan(&exists, &position)
+		if err != nil || exists {
+			return err
+		}
+
+		if err := insertAnalysis(ctx, tx, url, position, analysis); err != nil {
+			return err
+		}
+		//The analyses are read by time then position, so the analysis is the
+		//latest unless one is more recent
+		inserted = true
+		_, err = tx.ExecContext(ctx,
+			`UPDATE repository SET score = $2, grade = $3 WHERE url = $1
+			AND NOT EXISTS (SELECT 1 FROM analysis WHERE repository = $1 AND "time" > $4)`,
+			url, analysis.Score, analysis.Grade, analysis.Time,
+		)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return inserted, nil
+}
+
+//insertAnalysis inserts analysis of the repository at url and its leaks
+func insertAnalysis(ctx context.Context, tx *sql.Tx, url string, position int, analysis *Analysis) error {
+	var id int64
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO analysis (repository, position, hash, "time", status, reason, exported, score, grade)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		url, position, analysis.Hash, analysis.Time, analysis.Status,
+		analysis.Reason, analysis.Exported, analysis.Score, analysis.Grade,
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	leaks, err := tx.PrepareContext(ctx, `INSERT INTO leak (analysis, position, file, line, "column", message, kind, configurations)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+	if err != nil {
+		return err
+	}
+	defer leaks.Close()
+
+	for i, leak := range analysis.Leaks {
+		_, err := leaks.ExecContext(ctx, id, i, leak.File, leak.Line, leak.Column, leak.Message, leak.Kind, pq.Array(leak.Configurations))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//FindRepo finds a repo
+func (pg *postgres) FindRepo(ctx context.Context, url string) (Repository, error) {
+	defer pg.observe("find_repo", time.Now())
+
+	repositories, err := pg.find(ctx, `SELECT url, language, score, grade, requested FROM repository WHERE url = $1`, url)
+	if err != nil {
+		return Repository{}, err
+	}
+	if len(repositories) == 0 {
+		return Repository{}, ErrNotFound
+	}
+	return repositories[0], nil
+}
+
+//likeEscaper escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+//Each iterates over the repos with a URL prefix, read by batches
+func (pg *postgres) Each(ctx context.Context, prefix string, f func(repository Repository) error) error {
+	pattern := likeEscaper.Replace(prefix) + "%"
+	after := ""
+	for {
+		start := time.Now()
+		batch, err := pg.find(ctx,
+			`SELECT url, language, score, grade, requested FROM repository
+			WHERE url > $1 AND url LIKE $2 ORDER BY url LIMIT $3`,
+			after, pattern, eachBatch,
+		)
+		pg.observe("each", start)
+		if err != nil {
+			return err
+		}
+
+		if err := each(ctx, batch, f); err != nil {
+			return err
+		}
+		if len(batch) < eachBatch {
+			return nil
+		}
+		after = batch[len(batch)-1].URL
+	}
+}
+
+//TouchRepo records when a repo was requested
+func (pg *postgres) TouchRepo(ctx context.Context, url string, requested int64) error {
+	defer pg.observe("touch_repo", time.Now())
+
+	result, err := pg.db.ExecContext(ctx, `UPDATE repository SET requested = $2 WHERE url = $1`, url, requested)
+	return found(result, err)
+}
+
+//DeleteAnalyses deletes analyses of a repo by commit
+func (pg *postgres) DeleteAnalyses(ctx context.Context, url string, hashes []string, revision Revision) error {
+	defer pg.observe("delete_analyses", time.Now())
+
+	return transaction(ctx, pg.db, func(tx *sql.Tx) error {
+		if err := lockRevision(ctx, tx, url, revision); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM analysis WHERE repository = $1 AND hash = ANY($2)`,
+			url, pq.Array(hashes),
+		)
+		return err
+	})
+}
+
+//DeleteRepo deletes a repo; its analyses and their leaks cascade
+func (pg *postgres) DeleteRepo(ctx context.Context, url string, revision Revision) error {
+	defer pg.observe("delete_repo", time.Now())
+
+	return transaction(ctx, pg.db, func(tx *sql.Tx) error {
+		if err := lockRevision(ctx, tx, url, revision); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM repository WHERE url = $1`, url)
+		return err
+	})
+}
+
+//lockRevision locks the repository at url for the transaction and checks
+//that it is at revision
+func lockRevision(ctx context.Context, tx *sql.Tx, url string, revision Revision) error {
+	var current Revision
+	err := tx.QueryRowContext(ctx, `SELECT requested FROM repository WHERE url = $1 FOR UPDATE`, url).Scan(&current.Requested)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`SELECT count(*), coalesce((array_agg(hash ORDER BY "time" DESC, position DESC))[1], '')
+		FROM analysis WHERE repository = $1`,
+		url,
+	).Scan(&current.Analyses, &current.Latest)
+	if err != nil {
+		return err
+	}
+	if current != revision {
+		return ErrChanged
+	}
+	return nil
+}
+
+//found returns ErrNotFound when the statement of result changed no
+//repository
+func found(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if changed, err := result.RowsAffected(); err != nil {
+		return err
+	} else if changed == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//postgresOrders are the ORDER BY clauses of the sort orders of FindAll
+var postgresOrders = map[string]string{
+	"":       "",
+	"url":    "ORDER BY url",
+	"-url":   "ORDER BY url DESC",
+	"score":  "ORDER BY score, url",
+	"-score": "ORDER BY score DESC, url",
+}
+
+//FindAll retuns all the repo
+func (pg *postgres) FindAll(ctx context.Context, language string, skip int, limit int, sort string) ([]Repository, error) {
+	defer pg.observe("find_all", time.Now())
+
+	order, ok := postgresOrders[sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort %q", sort)
+	}
+
+	//A NULL limit doesn't limit
+	return pg.find(ctx,
+		`SELECT url, language, score, grade, requested FROM repository
+		WHERE $3 = '' OR language = $3 `+order+` LIMIT $1 OFFSET $2`,
+		sql.NullInt64{Int64: int64(limit), Valid: limit > 0}, skip, language,
+	)
+}
+
+//find returns the repositories selected by query along with their analyses
+func (pg *postgres) find(ctx context.Context, query string, args ...interface{}) ([]Repository, error) {
+	rows, err := pg.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repositories := []Repository{}
+	urls := []string{}
+	for rows.Next() {
+		//The tables are migrated when the store opens
+		repository := Repository{Analysis: []*Analysis{}, SchemaVersion: SchemaVersion}
+		if err := rows.Scan(&repository.URL, &repository.Language, &repository.Score, &repository.Grade, &repository.Requested); err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, repository)
+		urls = append(urls, repository.URL)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return repositories, nil
+	}
+
+	analyses, err := pg.analyses(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+	for i := range repositories {
+		if found, ok := analyses[repositories[i].URL]; ok {
+			repositories[i].Analysis = found
+		}
+	}
+	return repositories, nil
+}
+
+//analyses returns the analyses of the repositories at urls, with their
+//leaks, by repository URL
+func (pg *postgres) analyses(ctx context.Context, urls []string) (map[string][]*Analysis, error) {
+	rows, err := pg.db.QueryContext(ctx,
+		`SELECT id, repository, hash, "time", status, reason, exported, score, grade
+		FROM analysis WHERE repository = ANY($1) ORDER BY repository, "time", position`,
+		pq.Array(urls),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	analyses := map[string][]*Analysis{}
+	byID := map[int64]*Analysis{}
+	for rows.Next() {
+		var id int64
+		var repository string
+		analysis := &Analysis{Leaks: []*Leak{}}
+		err := rows.Scan(&id, &repository, &analysis.Hash, &analysis.Time, &analysis.Status,
+			&analysis.Reason, &analysis.Exported, &analysis.Score, &analysis.Grade)
+		if err != nil {
+			return nil, err
+		}
+		analyses[repository] = append(analyses[repository], analysis)
+		byID[id] = analysis
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	leaks, err := pg.db.QueryContext(ctx,
+		`SELECT leak.analysis, leak.file, leak.line, leak."column", leak.message, leak.kind, leak.configurations
+		FROM leak JOIN analysis ON analysis.id = leak.analysis
+		WHERE analysis.repository = ANY($1) ORDER BY leak.analysis, leak.position`,
+		pq.Array(urls),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer leaks.Close()
+
+	for leaks.Next() {
+		var id int64
+		leak := &Leak{}
+		if err := leaks.Scan(&id, &leak.File, &leak.Line, &leak.Column, &leak.Message, &leak.Kind, pq.Array(&leak.Configurations)); err != nil {
+			return nil, err
+		}
+		if analysis, ok := byID[id]; ok {
+			analysis.Leaks = append(analysis.Leaks, leak)
+		}
+	}
+	return analyses, leaks.Err()
+}
+
+//EnqueueJob queues the analysis of commit of repo (user/repo) in language
+//unless it is already queued or running. Failed jobs are queued again.
+func (pg *postgres) EnqueueJob(ctx context.Context, repo string, language string, commit string) error {
+	defer pg.observe("enqueue_job", time.Now())
+
+	job := newJob(repo, language, commit)
+	_, err := pg.db.ExecContext(ctx,
+		`INSERT INTO job (id, repo, language, "commit", status, created) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (repo, "commit") DO UPDATE SET status = $5, language = $3, created = $6 WHERE job.status = $7`,
+		job.ID, job.Repo, job.Language, job.Commit, JobQueued, job.Created, JobFailed,
+	)
+	return err
+}
+
+//ClaimJob atomically claims the oldest queued job, or a running job whose
+//lease expired, for worker
+func (pg *postgres) ClaimJob(ctx context.Context, worker string, lease time.Duration) (*Job, error) {
+	defer pg.observe("claim_job", time.Now())
+
+	now := time.Now()
+	var job Job
+	var expires pq.NullTime
+	err := pg.db.QueryRowContext(ctx,
+		`UPDATE job SET status = $1, worker = $2, lease = $3, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM job WHERE status = $4 OR (status = $1 AND lease < $5)
+			ORDER BY created LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, repo, language, "commit", status, worker, lease, attempts, created, error`,
+		JobRunning, worker, now.Add(lease), JobQueued, now,
+	).Scan(&job.ID, &job.Repo, &job.Language, &job.Commit, &job.Status, &job.Worker, &expires, &job.Attempts, &job.Created, &job.Error)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNoJob
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Lease = expires.Time
+	return &job, nil
+}
+
+//updateOwned runs update, whose two first parameters are the ID and the
+//worker of job, if job is still running for its worker
+func (pg *postgres) updateOwned(ctx context.Context, job *Job, update string, args ...interface{}) error {
+	result, err := pg.db.ExecContext(ctx, update, append([]interface{}{job.ID, job.Worker}, args...)...)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+//RenewJob extends the lease of a running job
+func (pg *postgres) RenewJob(ctx context.Context, job *Job, lease time.Duration) error {
+	defer pg.observe("renew_job", time.Now())
+
+	job.Lease = time.Now().Add(lease)
+	return pg.updateOwned(ctx, job,
+		`UPDATE job SET lease = $4 WHERE id = $1 AND worker = $2 AND status = $3`,
+		JobRunning, job.Lease,
+	)
+}
+
+//CompleteJob marks a running job as done, or as failed if err isn't nil
+func (pg *postgres) CompleteJob(ctx context.Context, job *Job, err error) error {
+	defer pg.observe("complete_job", time.Now())
+
+	complete(job, err)
+	return pg.updateOwned(ctx, job,
+		`UPDATE job SET status = $4, error = $5 WHERE id = $1 AND worker = $2 AND status = $3`,
+		JobRunning, job.Status, job.Error,
+	)
+}
+
+//ReleaseJob queues a running job again, for a worker stopping before the
+//job completes. The attempt isn't counted.
+func (pg *postgres) ReleaseJob(ctx context.Context, job *Job) error {
+	defer pg.observe("release_job", time.Now())
+
+	err := pg.updateOwned(ctx, job,
+		`UPDATE job SET status = $4, worker = '', attempts = attempts - 1
+		WHERE id = $1 AND worker = $2 AND status = $3`,
+		JobRunning, JobQueued,
+	)
+	if err == nil {
+		job.Status = JobQueued
+	}
+	return err
+}
+
+//CountJobs counts the jobs with status
+func (pg *postgres) CountJobs(ctx context.Context, status string) (int, error) {
+	defer pg.observe("count_jobs", time.Now())
+
+	var count int
+	err := pg.db.QueryRowContext(ctx, `SELECT count(*) FROM job WHERE status = $1`, status).Scan(&count)
+	return count, err
+}
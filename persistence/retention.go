@@ -0,0 +1,124 @@
+package persistence
+
+import (
+	"context"
+	"time"
+)
+
+//Retention tells which analyses and repositories Prune keeps
+type Retention struct {
+	//Analyses is the number of latest analyses kept per repository, along
+	//with the latest one of each month; all of them if 0
+	Analyses int
+	//Unrequested is how long a repository is kept once nobody requests it;
+	//forever if 0
+	Unrequested time.Duration
+}
+
+//Revision is the state of a repository the deletions of Prune are made
+//for; the repositories requested or analyzed meanwhile are left alone
+type Revision struct {
+	Requested int64
+	//Analyses is the number of analyses and Latest the commit of the
+	//latest one
+	Analyses int
+	Latest   string
+}
+
+//Revision returns the current state of the repository
+func (r Repository) Revision() Revision {
+	revision := Revision{Requested: r.Requested, Analyses: len(r.Analysis)}
+	if latest := r.Latest(); latest != nil {
+		revision.Latest = latest.Hash
+	}
+	return revision
+}
+
+//Pruning is what Prune deletes from a repository
+type Pruning struct {
+	URL string `json:"url"`
+	//Repository tells whether the whole repository is deleted
+	Repository bool `json:"repository"`
+	//Hashes are the commits of the deleted analyses
+	Hashes []string `json:"hashes,omitempty"`
+
+	revision Revision
+}
+
+//pruning returns what isn't kept of repository at now, and whether there
+//is anything
+func (r Retention) pruning(repository Repository, now time.Time) (Pruning, bool) {
+	pruning := Pruning{URL: repository.URL, revision: repository.Revision()}
+
+	//The repositories analyzed before the requests were tracked count as
+	//requested when they were last analyzed
+	requested := repository.Requested
+	if latest := repository.Latest(); latest != nil && latest.Time > requested {
+		requested = latest.Time
+	}
+	if r.Unrequested > 0 && time.Unix(requested, 0).Before(now.Add(-r.Unrequested)) {
+		pruning.Repository = true
+		return pruning, true
+	}
+
+	if r.Analyses <= 0 || len(repository.Analysis) <= r.Analyses {
+		return pruning, false
+	}
+
+	kept := map[int]bool{}
+	months := map[string]bool{}
+	for i := len(repository.Analysis) - 1; i >= 0; i-- {
+		month := time.Unix(repository.Analysis[i].Time, 0).UTC().Format("2006-01")
+		if i >= len(repository.Analysis)-r.Analyses || !months[month] {
+			kept[i] = true
+		}
+		months[month] = true
+	}
+
+	for i, analysis := range repository.Analysis {
+		if !kept[i] {
+			pruning.Hashes = append(pruning.Hashes, analysis.Hash)
+		}
+	}
+	return pruning, len(pruning.Hashes) > 0
+}
+
+//Prune deletes from dao the analyses and the repositories retention
+//doesn't keep at now, and returns them. Nothing is deleted if dryRun. The
+//repositories changed since they were read are left to the next run.
+func Prune(ctx context.Context, dao DAO, retention Retention, now time.Time, dryRun bool) ([]Pruning, error) {
+	//Everything is read before deleting, out of the way of the iteration
+	prunings := []Pruning{}
+	err := dao.Each(ctx, "", func(repository Repository) error {
+		if pruning, ok := retention.pruning(repository, now); ok {
+			prunings = append(prunings, pruning)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return prunings, nil
+	}
+
+	pruned := []Pruning{}
+	for _, pruning := range prunings {
+		var err error
+		if pruning.Repository {
+			err = dao.DeleteRepo(ctx, pruning.URL, pruning.revision)
+		} else {
+			err = dao.DeleteAnalyses(ctx, pruning.URL, pruning.Hashes, pruning.revision)
+		}
+		switch err {
+		//A repository deleted meanwhile is as good as pruned
+		case nil, ErrNotFound:
+			pruned = append(pruned, pruning)
+		case ErrChanged:
+		default:
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
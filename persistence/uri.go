@@ -0,0 +1,318 @@
+package persistence
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+//mongoURI is a parsed MongoDB connection string
+type mongoURI struct {
+	//hosts are the seed servers, or the SRV name for mongodb+srv://
+	hosts    []string
+	srv      bool
+	database string
+	user     string
+	password string
+	//hasPassword tells an empty password from a missing one
+	hasPassword bool
+	options     url.Values
+}
+
+//readPreferences are the modes of the readPreference option
+var readPreferences = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primaryPreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondaryPreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
+//mongoOptions are the supported options of the connection strings
+var mongoOptions = map[string]bool{
+	"authSource":       true,
+	"authMechanism":    true,
+	"replicaSet":       true,
+	"readPreference":   true,
+	"w":                true,
+	"wtimeoutMS":       true,
+	"journal":          true,
+	"tls":              true,
+	"ssl":              true,
+	"tlsCAFile":        true,
+	"tlsInsecure":      true,
+	"connectTimeoutMS": true,
+	"maxPoolSize":      true,
+	"appName":          true,
+}
+
+//parseMongoURI parses a mongodb:// or mongodb+srv:// connection string. An
+//address without scheme, like localhost:27017, is accepted as well.
+func parseMongoURI(uri string) (*mongoURI, error) {
+	parsed := &mongoURI{}
+
+	switch {
+	case strings.HasPrefix(uri, "mongodb+srv://"):
+		parsed.srv = true
+		uri = strings.TrimPrefix(uri, "mongodb+srv://")
+	case strings.HasPrefix(uri, "mongodb://"):
+		uri = strings.TrimPrefix(uri, "mongodb://")
+	case strings.Contains(uri, "://"):
+		return nil, errors.New("the MongoDB URI must start with mongodb:// or mongodb+srv://")
+	}
+
+	//The query and the credentials are split off first as the password may
+	//contain any escaped character
+	if i := strings.Index(uri, "?"); i != -1 {
+		options, err := url.ParseQuery(uri[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid MongoDB URI options: %v", err)
+		}
+		parsed.options = options
+		uri = uri[:i]
+	} else {
+		parsed.options = url.Values{}
+	}
+
+	if i := strings.LastIndex(uri, "@"); i != -1 {
+		credentials := strings.SplitN(uri[:i], ":", 2)
+		user, err := url.QueryUnescape(credentials[0])
+		if err != nil || user == "" {
+			return nil, errors.New("invalid MongoDB URI user; escape the special characters")
+		}
+		parsed.user = user
+		if len(credentials) == 2 {
+			password, err := url.QueryUnescape(credentials[1])
+			if err != nil {
+				return nil, errors.New("invalid MongoDB URI password; escape the special characters")
+			}
+			parsed.password, parsed.hasPassword = password, true
+		}
+		uri = uri[i+1:]
+	}
+
+	if i := strings.Index(uri, "/"); i != -1 {
+		database, err := url.PathUnescape(uri[i+1:])
+		if err != nil {
+			return nil, errors.New("invalid MongoDB URI database")
+		}
+		parsed.database = database
+		uri = uri[:i]
+	}
+
+	for _, host := range strings.Split(uri, ",") {
+		if host == "" {
+			return nil, errors.New("the MongoDB URI has no host")
+		}
+		parsed.hosts = append(parsed.hosts, host)
+	}
+	if parsed.srv && (len(parsed.hosts) != 1 || strings.Contains(parsed.hosts[0], ":")) {
+		return nil, errors.New("a mongodb+srv:// URI must have a single host name without port")
+	}
+
+	if err := parsed.checkOptions(); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+//checkOptions refuses the unknown options and the invalid values
+func (u *mongoURI) checkOptions() error {
+	for name := range u.options {
+		if !mongoOptions[name] {
+			return fmt.Errorf("unsupported MongoDB URI option %s", name)
+		}
+	}
+
+	if preference := u.options.Get("readPreference"); preference != "" {
+		if _, ok := readPreferences[preference]; !ok {
+			return fmt.Errorf("invalid MongoDB readPreference %q; expected primary, primaryPreferred, secondary, secondaryPreferred or nearest", preference)
+		}
+	}
+
+	for _, name := range []string{"tls", "ssl", "tlsInsecure", "journal"} {
+		if value := u.options.Get(name); value != "" {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("invalid MongoDB %s %q; expected true or false", name, value)
+			}
+		}
+	}
+
+	for _, name := range []string{"wtimeoutMS", "connectTimeoutMS", "maxPoolSize"} {
+		if value := u.options.Get(name); value != "" {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return fmt.Errorf("invalid MongoDB %s %q; expected a positive integer", name, value)
+			}
+		}
+	}
+
+	if w := u.options.Get("w"); w != "" && w != "majority" {
+		if n, err := strconv.Atoi(w); err != nil || n < 0 {
+			return fmt.Errorf("invalid MongoDB write concern w=%q; expected majority or a number", w)
+		}
+	}
+	return nil
+}
+
+//flag returns the boolean option name, false if it isn't set
+func (u *mongoURI) flag(name string) bool {
+	value, _ := strconv.ParseBool(u.options.Get(name))
+	return value
+}
+
+//resolve looks the seed servers and the options of a mongodb+srv:// URI up
+//in the DNS. The options of the URI take precedence over the TXT record.
+func (u *mongoURI) resolve(lookupSRV func(service, proto, name string) (string, []*net.SRV, error), lookupTXT func(name string) ([]string, error)) error {
+	if !u.srv {
+		return nil
+	}
+	name := u.hosts[0]
+
+	_, records, err := lookupSRV("mongodb", "tcp", name)
+	if err != nil {
+		return fmt.Errorf("can't look the MongoDB servers of %s up: %v", name, err)
+	}
+	u.hosts = nil
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		if !strings.HasSuffix(host, "."+domainOf(name)) {
+			return fmt.Errorf("the MongoDB server %s isn't in the domain of %s", host, name)
+		}
+		u.hosts = append(u.hosts, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+
+	txt, err := lookupTXT(name)
+	if err == nil && len(txt) > 1 {
+		return fmt.Errorf("%s has more than one TXT record", name)
+	}
+	if err == nil && len(txt) == 1 {
+		options, err := url.ParseQuery(txt[0])
+		if err != nil {
+			return fmt.Errorf("invalid TXT record for %s: %v", name, err)
+		}
+		for option, values := range options {
+			if option != "authSource" && option != "replicaSet" {
+				return fmt.Errorf("the TXT record of %s can only set authSource and replicaSet; got %s", name, option)
+			}
+			if u.options.Get(option) == "" {
+				u.options[option] = values
+			}
+		}
+	}
+
+	//TLS is on by default for mongodb+srv://
+	if u.options.Get("tls") == "" && u.options.Get("ssl") == "" {
+		u.options.Set("tls", "true")
+	}
+	return nil
+}
+
+//domainOf returns the parent domain of a host name
+func domainOf(name string) string {
+	if i := strings.Index(name, "."); i != -1 {
+		return name[i+1:]
+	}
+	return name
+}
+
+//dialInfo returns the dial information of the URI once resolved
+func (u *mongoURI) dialInfo() (*mgo.DialInfo, error) {
+	info := &mgo.DialInfo{
+		Addrs:          u.hosts,
+		Database:       u.database,
+		Username:       u.user,
+		Password:       u.password,
+		Source:         u.options.Get("authSource"),
+		Mechanism:      u.options.Get("authMechanism"),
+		ReplicaSetName: u.options.Get("replicaSet"),
+		Timeout:        60 * time.Second,
+	}
+
+	if timeout, err := strconv.Atoi(u.options.Get("connectTimeoutMS")); err == nil && timeout > 0 {
+		info.Timeout = time.Duration(timeout) * time.Millisecond
+	}
+	if limit, err := strconv.Atoi(u.options.Get("maxPoolSize")); err == nil {
+		info.PoolLimit = limit
+	}
+
+	if u.flag("tls") || u.flag("ssl") {
+		config := &tls.Config{InsecureSkipVerify: u.flag("tlsInsecure")}
+
+		if file := u.options.Get("tlsCAFile"); file != "" {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("can't read the MongoDB CA: %v", err)
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in the MongoDB CA %s", file)
+			}
+		}
+
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: info.Timeout}
+			serverConfig := config.Clone()
+			serverConfig.ServerName, _, _ = net.SplitHostPort(addr.String())
+			return tls.DialWithDialer(dialer, "tcp", addr.String(), serverConfig)
+		}
+	}
+
+	return info, nil
+}
+
+//mode returns the read preference of the URI. Reads are monotonic by
+//default.
+func (u *mongoURI) mode() mgo.Mode {
+	if mode, ok := readPreferences[u.options.Get("readPreference")]; ok {
+		return mode
+	}
+	return mgo.Monotonic
+}
+
+//safe returns the write concern of the URI, or nil to keep the default of
+//the driver
+func (u *mongoURI) safe() *mgo.Safe {
+	w, timeout, journal := u.options.Get("w"), u.options.Get("wtimeoutMS"), u.options.Get("journal")
+	if w == "" && timeout == "" && journal == "" {
+		return nil
+	}
+
+	safe := &mgo.Safe{J: u.flag("journal")}
+	if w == "majority" {
+		safe.WMode = w
+	} else if n, err := strconv.Atoi(w); err == nil {
+		safe.W = n
+	}
+	safe.WTimeout, _ = strconv.Atoi(timeout)
+	return safe
+}
+
+//redactURI returns the URI without its password
+func redactURI(uri string) string {
+	scheme := ""
+	for _, prefix := range []string{"mongodb+srv://", "mongodb://"} {
+		if strings.HasPrefix(uri, prefix) {
+			scheme, uri = prefix, strings.TrimPrefix(uri, prefix)
+		}
+	}
+
+	end := len(uri)
+	if i := strings.IndexAny(uri, "/?"); i != -1 {
+		end = i
+	}
+	if at := strings.LastIndex(uri[:end], "@"); at != -1 {
+		if colon := strings.Index(uri[:at], ":"); colon != -1 {
+			uri = uri[:colon+1] + "REDACTED" + uri[at:]
+		}
+	}
+	return scheme + uri
+}
@@ -0,0 +1,80 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//BadgeContentType is the media type of the badges
+const BadgeContentType = "image/svg+xml; charset=utf-8"
+
+//Badge colours, from shields.io
+const (
+	badgeGreen  = "#4c1"
+	badgeYellow = "#dfb317"
+	badgeOrange = "#fe7d37"
+	badgeRed    = "#e05d44"
+	badgeGrey   = "#9f9f9f"
+)
+
+//badgeThresholds are the colours of the badges for at most Leaks leaks
+var badgeThresholds = []struct {
+	Leaks  int
+	Colour string
+}{
+	{Leaks: 0, Colour: badgeGreen},
+	{Leaks: 5, Colour: badgeYellow},
+	{Leaks: 20, Colour: badgeOrange},
+}
+
+//LeaksBadge renders a badge showing the leak count of the latest analysis
+//of repository, or "unknown" when there is none
+func LeaksBadge(repository persistence.Repository) []byte {
+	analysis := repository.Latest()
+
+	switch {
+	case analysis == nil:
+		return Badge("leaks", "unknown", badgeGrey)
+	case analysis.Status != "" && analysis.Status != persistence.StatusDone:
+		return Badge("leaks", analysis.Status, badgeGrey)
+	}
+
+	leaks := len(analysis.Leaks)
+	colour := badgeRed
+	for _, threshold := range badgeThresholds {
+		if leaks <= threshold.Leaks {
+			colour = threshold.Colour
+			break
+		}
+	}
+	return Badge("leaks", strconv.Itoa(leaks), colour)
+}
+
+//Badge renders a shields-style badge
+func Badge(label string, message string, colour string) []byte {
+	labelWidth := textWidth(label)
+	messageWidth := textWidth(message)
+	width := labelWidth + messageWidth
+	label, message = html.EscapeString(label), html.EscapeString(message)
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, width, label, message)
+	fmt.Fprintf(&buffer, `<title>%s: %s</title>`, label, message)
+	buffer.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&buffer, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+	fmt.Fprintf(&buffer, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`, labelWidth, labelWidth, messageWidth, colour, width)
+	buffer.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&buffer, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, labelWidth/2, label, labelWidth/2, label)
+	fmt.Fprintf(&buffer, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, labelWidth+messageWidth/2, message, labelWidth+messageWidth/2, message)
+	buffer.WriteString(`</g></svg>`)
+	return buffer.Bytes()
+}
+
+//textWidth approximates the width of a text in 11px Verdana plus padding
+func textWidth(text string) int {
+	return len(text)*7 + 10
+}
@@ -0,0 +1,46 @@
+package render
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+func TestLeaksBadge(t *testing.T) {
+
+	leaks := func(n int) []*persistence.Leak {
+		return make([]*persistence.Leak, n)
+	}
+
+	testCases := []struct {
+		Name       string
+		Repository persistence.Repository
+		Message    string
+		Colour     string
+	}{
+		{Name: "unknown", Repository: persistence.Repository{}, Message: "unknown", Colour: badgeGrey},
+		{Name: "refused", Repository: persistence.Repository{Analysis: []*persistence.Analysis{{Status: persistence.StatusRefused}}}, Message: "refused", Colour: badgeGrey},
+		{Name: "none", Repository: persistence.Repository{Analysis: []*persistence.Analysis{{Leaks: leaks(3)}, {Leaks: leaks(0)}}}, Message: "0", Colour: badgeGreen},
+		{Name: "few", Repository: persistence.Repository{Analysis: []*persistence.Analysis{{Leaks: leaks(5)}}}, Message: "5", Colour: badgeYellow},
+		{Name: "some", Repository: persistence.Repository{Analysis: []*persistence.Analysis{{Leaks: leaks(6)}}}, Message: "6", Colour: badgeOrange},
+		{Name: "many", Repository: persistence.Repository{Analysis: []*persistence.Analysis{{Leaks: leaks(21)}}}, Message: "21", Colour: badgeRed},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			badge := string(LeaksBadge(testCase.Repository))
+
+			if err := xml.Unmarshal([]byte(badge), new(struct{})); err != nil {
+				t.Errorf("expected valid SVG; got %s", err)
+			}
+			if !strings.Contains(badge, "<title>leaks: "+testCase.Message+"</title>") {
+				t.Errorf("expected message %s in %s", testCase.Message, badge)
+			}
+			if !strings.Contains(badge, `fill="`+testCase.Colour+`"`) {
+				t.Errorf("expected colour %s in %s", testCase.Colour, badge)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package render
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//JSONRenderer renders the documents as stored
+type JSONRenderer struct{}
+
+func (JSONRenderer) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (JSONRenderer) Repository(repository persistence.Repository) ([]byte, error) {
+	return json.MarshalIndent(repository, "", "  ")
+}
+
+func (JSONRenderer) Analysis(repository persistence.Repository, analysis *persistence.Analysis) ([]byte, error) {
+	return json.MarshalIndent(analysis, "", "  ")
+}
+
+func (JSONRenderer) Repositories(repositories []persistence.Repository) ([]byte, error) {
+	return json.MarshalIndent(repositories, "", "  ")
+}
+
+//sarifRenderer renders the latest analyses as SARIF logs
+type sarifRenderer struct{}
+
+func (sarifRenderer) ContentType() string {
+	return SARIFContentType
+}
+
+func (r sarifRenderer) Repository(repository persistence.Repository) ([]byte, error) {
+	analysis, err := latest(repository)
+	if err != nil {
+		return nil, err
+	}
+	return SARIF(repository, analysis)
+}
+
+func (sarifRenderer) Analysis(repository persistence.Repository, analysis *persistence.Analysis) ([]byte, error) {
+	return SARIF(repository, analysis)
+}
+
+func (sarifRenderer) Repositories(repositories []persistence.Repository) ([]byte, error) {
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{},
+	}
+	for _, repository := range repositories {
+		if analysis := repository.Latest(); analysis != nil {
+			log.Runs = append(log.Runs, sarifRunOf(repository, analysis))
+		}
+	}
+	return json.MarshalIndent(log, "", "  ")
+}
+
+//csvRenderer renders one row per leak of the latest analyses
+type csvRenderer struct{}
+
+//csvHeader is the first row of the CSV documents
+var csvHeader = []string{"repository", "commit", "file", "line", "column", "kind", "message"}
+
+func (csvRenderer) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (r csvRenderer) Repository(repository persistence.Repository) ([]byte, error) {
+	analysis, err := latest(repository)
+	if err != nil {
+		return nil, err
+	}
+	return r.Analysis(repository, analysis)
+}
+
+func (csvRenderer) Analysis(repository persistence.Repository, analysis *persistence.Analysis) ([]byte, error) {
+	return writeCSV(func(w *csv.Writer) {
+		writeCSVLeaks(w, repository, analysis)
+	})
+}
+
+func (csvRenderer) Repositories(repositories []persistence.Repository) ([]byte, error) {
+	return writeCSV(func(w *csv.Writer) {
+		for _, repository := range repositories {
+			if analysis := repository.Latest(); analysis != nil {
+				writeCSVLeaks(w, repository, analysis)
+			}
+		}
+	})
+}
+
+func writeCSV(rows func(w *csv.Writer)) ([]byte, error) {
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	w.Write(csvHeader)
+	rows(w)
+	w.Flush()
+	return buffer.Bytes(), w.Error()
+}
+
+func writeCSVLeaks(w *csv.Writer, repository persistence.Repository, analysis *persistence.Analysis) {
+	for _, leak := range analysis.Leaks {
+		w.Write([]string{
+			repository.URL,
+			analysis.Hash,
+			relativeFile(repository, leak),
+			strconv.Itoa(leak.Line),
+			strconv.Itoa(leak.Column),
+			kind(leak),
+			leak.Message,
+		})
+	}
+}
+
+//markdownRenderer renders reports suitable for pull requests
+type markdownRenderer struct{}
+
+func (markdownRenderer) ContentType() string {
+	return "text/markdown; charset=utf-8"
+}
+
+func (r markdownRenderer) Repository(repository persistence.Repository) ([]byte, error) {
+	analysis, err := latest(repository)
+	if err != nil {
+		return nil, err
+	}
+	return r.Analysis(repository, analysis)
+}
+
+func (markdownRenderer) Analysis(repository persistence.Repository, analysis *persistence.Analysis) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	fmt.Fprintf(&buffer, "## depbleed report for %s\n\n", repository.URL)
+	fmt.Fprintf(&buffer, "Commit `%s`, analyzed on %s.\n\n", analysis.Hash, time.Unix(analysis.Time, 0).UTC().Format(time.RFC1123))
+
+	switch {
+	case analysis.Status != "" && analysis.Status != persistence.StatusDone:
+		fmt.Fprintf(&buffer, "The analysis was %s: %s\n", analysis.Status, analysis.Reason)
+	case len(analysis.Leaks) == 0:
+		buffer.WriteString("No dependency leaks found.\n")
+	default:
+		fmt.Fprintf(&buffer, "%d dependency leaks found, score %.2f (grade %s).\n\n", len(analysis.Leaks), analysis.Score, analysis.Grade)
+		buffer.WriteString("| File | Line | Kind | Message |\n")
+		buffer.WriteString("| --- | ---: | --- | --- |\n")
+		for _, leak := range analysis.Leaks {
+			fmt.Fprintf(&buffer, "| `%s` | %d | %s | %s |\n", relativeFile(repository, leak), leak.Line, kind(leak), escapeMarkdown(leak.Message))
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+func (markdownRenderer) Repositories(repositories []persistence.Repository) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("| Repository | Commit | Leaks | Score | Grade |\n")
+	buffer.WriteString("| --- | --- | ---: | ---: | --- |\n")
+	for _, repository := range repositories {
+		if analysis := repository.Latest(); analysis != nil {
+			fmt.Fprintf(&buffer, "| %s | `%s` | %d | %.2f | %s |\n", repository.URL, analysis.Hash, len(analysis.Leaks), analysis.Score, analysis.Grade)
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+//escapeMarkdown escapes the characters breaking a table cell
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
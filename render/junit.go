@@ -0,0 +1,122 @@
+package render
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//junitRenderer renders the leaks as failing test cases
+type junitRenderer struct{}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (junitRenderer) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+func (r junitRenderer) Repository(repository persistence.Repository) ([]byte, error) {
+	analysis, err := latest(repository)
+	if err != nil {
+		return nil, err
+	}
+	return r.Analysis(repository, analysis)
+}
+
+func (junitRenderer) Analysis(repository persistence.Repository, analysis *persistence.Analysis) ([]byte, error) {
+	return marshalJUnit([]junitTestSuite{junitSuiteOf(repository, analysis)})
+}
+
+func (junitRenderer) Repositories(repositories []persistence.Repository) ([]byte, error) {
+	suites := []junitTestSuite{}
+	for _, repository := range repositories {
+		if analysis := repository.Latest(); analysis != nil {
+			suites = append(suites, junitSuiteOf(repository, analysis))
+		}
+	}
+	return marshalJUnit(suites)
+}
+
+//junitSuiteOf returns a test suite with one failing test case per leak, or
+//one passing test case when there is none
+func junitSuiteOf(repository persistence.Repository, analysis *persistence.Analysis) junitTestSuite {
+	suite := junitTestSuite{Name: repository.URL}
+
+	for _, leak := range analysis.Leaks {
+		file := relativeFile(repository, leak)
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      fmt.Sprintf("%s:%d:%d", file, leak.Line, leak.Column),
+			ClassName: repository.URL,
+			Failure: &junitFailure{
+				Message: leak.Message,
+				Type:    kind(leak),
+				Text:    fmt.Sprintf("%s:%d:%d: %s", file, leak.Line, leak.Column, leak.Message),
+			},
+		})
+	}
+
+	if analysis.Status != "" && analysis.Status != persistence.StatusDone {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      "analysis",
+			ClassName: repository.URL,
+			Failure: &junitFailure{
+				Message: analysis.Reason,
+				Type:    analysis.Status,
+			},
+		})
+	}
+
+	if len(suite.Cases) == 0 {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      "no dependency leaks",
+			ClassName: repository.URL,
+		})
+	}
+
+	suite.Tests = len(suite.Cases)
+	for _, testCase := range suite.Cases {
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+	}
+	return suite
+}
+
+func marshalJUnit(suites []junitTestSuite) ([]byte, error) {
+	document := junitTestSuites{Suites: suites}
+	for _, suite := range suites {
+		document.Tests += suite.Tests
+		document.Failures += suite.Failures
+	}
+
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
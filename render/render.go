@@ -0,0 +1,145 @@
+//Package render renders the stored analyses in the formats the API serves
+package render
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//ErrNoAnalysis is returned when rendering the analysis of a repository that
+//was never analyzed in a format that only shows analyses
+var ErrNoAnalysis = errors.New("repository was never analyzed")
+
+//Renderer renders the stored documents in one format
+type Renderer interface {
+	ContentType() string
+	Repository(repository persistence.Repository) ([]byte, error)
+	Analysis(repository persistence.Repository, analysis *persistence.Analysis) ([]byte, error)
+	Repositories(repositories []persistence.Repository) ([]byte, error)
+}
+
+//renderers are the supported formats, by the name used with ?format=
+var renderers = map[string]Renderer{
+	"json":     JSONRenderer{},
+	"sarif":    sarifRenderer{},
+	"csv":      csvRenderer{},
+	"markdown": markdownRenderer{},
+	"junit":    junitRenderer{},
+}
+
+//mediaTypes maps the media types of the Accept header to the formats
+var mediaTypes = map[string]string{
+	"application/json":        "json",
+	SARIFContentType:          "sarif",
+	"text/csv":                "csv",
+	"text/markdown":           "markdown",
+	"application/xml":         "junit",
+	"text/xml":                "junit",
+	"application/junit+xml":   "junit",
+	"application/x-junit+xml": "junit",
+}
+
+//Negotiate returns the renderer for the format requested with ?format= or,
+//failing that, with the Accept header. JSON is used when neither is given.
+//The returned status is http.StatusBadRequest for an unknown ?format= and
+//http.StatusNotAcceptable when no accepted media type is supported.
+func Negotiate(r *http.Request) (Renderer, int, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		renderer, ok := renderers[strings.ToLower(format)]
+		if !ok {
+			return nil, http.StatusBadRequest, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats(), ", "))
+		}
+		return renderer, http.StatusOK, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return renderers["json"], http.StatusOK, nil
+	}
+
+	for _, mediaType := range acceptedMediaTypes(accept) {
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return renderers["json"], http.StatusOK, nil
+		}
+		if format, ok := mediaTypes[mediaType]; ok {
+			return renderers[format], http.StatusOK, nil
+		}
+	}
+	return nil, http.StatusNotAcceptable, fmt.Errorf("none of %q is supported, expected one of %s", accept, strings.Join(Formats(), ", "))
+}
+
+//Formats returns the names of the supported formats
+func Formats() []string {
+	formats := []string{}
+	for format := range renderers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+//acceptedMediaTypes returns the media types of an Accept header by
+//decreasing quality, without the ones with a quality of 0
+func acceptedMediaTypes(accept string) []string {
+	type accepted struct {
+		mediaType string
+		quality   float64
+	}
+	ranges := []accepted{}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, accepted{mediaType: mediaType, quality: quality})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	mediaTypes := []string{}
+	for _, r := range ranges {
+		mediaTypes = append(mediaTypes, r.mediaType)
+	}
+	return mediaTypes
+}
+
+//latest returns the latest analysis of repository or ErrNoAnalysis
+func latest(repository persistence.Repository) (*persistence.Analysis, error) {
+	analysis := repository.Latest()
+	if analysis == nil {
+		return nil, ErrNoAnalysis
+	}
+	return analysis, nil
+}
+
+//relativeFile returns the file of a leak relative to the root of repository
+func relativeFile(repository persistence.Repository, leak *persistence.Leak) string {
+	return strings.TrimPrefix(leak.File, strings.TrimPrefix(repository.URL, "github.com/")+"/")
+}
+
+//kind returns the kind of a leak, including for the leaks stored before
+//their kind was
+func kind(leak *persistence.Leak) string {
+	if leak.Kind == "" {
+		return persistence.LeakKindOf(leak.Message)
+	}
+	return leak.Kind
+}
@@ -0,0 +1,155 @@
+package render
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+var testRepository = persistence.Repository{
+	URL: "github.com/user/repo",
+	Analysis: []*persistence.Analysis{
+		{
+			Hash:   "abc",
+			Status: persistence.StatusDone,
+			Score:  80,
+			Grade:  "C",
+			Leaks: []*persistence.Leak{
+				{File: "user/repo/a.go", Line: 3, Column: 6, Message: "F: x.T | y", Kind: persistence.LeakGlobal},
+				{File: "user/repo/b.go", Line: 9, Column: 1, Message: "G: z.T", Kind: persistence.LeakVendored},
+			},
+		},
+	},
+}
+
+func TestNegotiate(t *testing.T) {
+
+	testCases := []struct {
+		URL         string
+		Accept      string
+		ContentType string
+		Code        int
+	}{
+		{URL: "/", ContentType: "application/json; charset=utf-8", Code: http.StatusOK},
+		{URL: "/?format=csv", Accept: "application/json", ContentType: "text/csv; charset=utf-8", Code: http.StatusOK},
+		{URL: "/?format=SARIF", ContentType: SARIFContentType, Code: http.StatusOK},
+		{URL: "/?format=pdf", Code: http.StatusBadRequest},
+		{URL: "/", Accept: "text/html;q=0.9, text/markdown", ContentType: "text/markdown; charset=utf-8", Code: http.StatusOK},
+		{URL: "/", Accept: "text/csv;q=0.5, application/xml;q=0.8", ContentType: "application/xml; charset=utf-8", Code: http.StatusOK},
+		{URL: "/", Accept: "text/html, */*;q=0.1", ContentType: "application/json; charset=utf-8", Code: http.StatusOK},
+		{URL: "/", Accept: "text/html", Code: http.StatusNotAcceptable},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.URL+" "+testCase.Accept, func(t *testing.T) {
+			r := httptest.NewRequest("GET", testCase.URL, nil)
+			if testCase.Accept != "" {
+				r.Header.Set("Accept", testCase.Accept)
+			}
+
+			renderer, code, err := Negotiate(r)
+
+			if code != testCase.Code {
+				t.Fatalf("expected status %d; got %d (%v)", testCase.Code, code, err)
+			}
+			if testCase.ContentType == "" {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if renderer.ContentType() != testCase.ContentType {
+				t.Errorf("expected content type %s; got %s", testCase.ContentType, renderer.ContentType())
+			}
+		})
+	}
+}
+
+func TestCSV(t *testing.T) {
+	body, err := renderers["csv"].Repository(testRepository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected a header and 2 rows; got %d", len(rows))
+	}
+	expected := "github.com/user/repo,abc,b.go,9,1,vendored,G: z.T"
+	if strings.Join(rows[2], ",") != expected {
+		t.Errorf("expected %s; got %s", expected, strings.Join(rows[2], ","))
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	body, err := renderers["markdown"].Repository(testRepository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"## depbleed report for github.com/user/repo",
+		"2 dependency leaks found, score 80.00 (grade C).",
+		"| `a.go` | 3 | global | F: x.T \\| y |",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %q in %s", expected, body)
+		}
+	}
+}
+
+func TestJUnit(t *testing.T) {
+	body, err := renderers["junit"].Repositories([]persistence.Repository{
+		testRepository,
+		{URL: "github.com/user/never"},
+		{URL: "github.com/user/clean", Analysis: []*persistence.Analysis{{Hash: "def"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(body, &suites); err != nil {
+		t.Fatal(err)
+	}
+	if len(suites.Suites) != 2 || suites.Tests != 3 || suites.Failures != 2 {
+		t.Errorf("expected 2 suites, 3 tests and 2 failures; got %d, %d and %d", len(suites.Suites), suites.Tests, suites.Failures)
+	}
+	if suites.Suites[0].Cases[0].Name != "a.go:3:6" {
+		t.Errorf("expected test case a.go:3:6; got %s", suites.Suites[0].Cases[0].Name)
+	}
+}
+
+func TestNoAnalysis(t *testing.T) {
+	for _, format := range Formats() {
+		_, err := renderers[format].Repository(persistence.Repository{URL: "github.com/user/never"})
+		if format == "json" && err != nil {
+			t.Errorf("expected json to render repositories without analysis; got %s", err)
+		}
+		if format != "json" && err != ErrNoAnalysis {
+			t.Errorf("expected ErrNoAnalysis for %s; got %v", format, err)
+		}
+	}
+}
+
+func TestJSONKeys(t *testing.T) {
+	repository := testRepository
+	repository.Language = "GO"
+	repository.SchemaVersion = 2
+	body, err := renderers["json"].Repository(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"language": "GO"`) || strings.Contains(string(body), "schema_version") {
+		t.Errorf("expected the language without the storage schema version; got %s", body)
+	}
+}
@@ -0,0 +1,148 @@
+package render
+
+import (
+	"encoding/json"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+//SARIFContentType is the media type of SARIF logs
+const SARIFContentType = "application/sarif+json"
+
+//sarifRules are the SARIF rules, one per leak kind
+var sarifRules = []sarifRule{
+	{
+		ID:               persistence.LeakVendored,
+		Name:             "VendoredTypeLeak",
+		ShortDescription: sarifMessage{Text: "An exported identifier exposes a vendored type."},
+	},
+	{
+		ID:               persistence.LeakGlobal,
+		Name:             "GlobalTypeLeak",
+		ShortDescription: sarifMessage{Text: "An exported identifier exposes a type of another package."},
+	},
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool                     sarifTool                `json:"tool"`
+	Results                  []sarifResult            `json:"results"`
+	VersionControlProvenance []sarifVersionControl    `json:"versionControlProvenance"`
+	OriginalURIBaseIDs       map[string]sarifArtifact `json:"originalUriBaseIds"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           sarifRegion   `json:"region"`
+}
+
+type sarifArtifact struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+}
+
+type sarifVersionControl struct {
+	RepositoryURI string `json:"repositoryUri"`
+	RevisionID    string `json:"revisionId"`
+}
+
+//SARIF renders an analysis of repository as a SARIF 2.1.0 log
+func SARIF(repository persistence.Repository, analysis *persistence.Analysis) ([]byte, error) {
+	return json.MarshalIndent(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{sarifRunOf(repository, analysis)},
+	}, "", "  ")
+}
+
+//sarifRunOf returns the SARIF run of an analysis of repository
+func sarifRunOf(repository persistence.Repository, analysis *persistence.Analysis) sarifRun {
+	repositoryURI := "https://" + repository.URL
+
+	results := []sarifResult{}
+	for _, leak := range analysis.Leaks {
+		ruleIndex := 0
+		for i, rule := range sarifRules {
+			if rule.ID == kind(leak) {
+				ruleIndex = i
+			}
+		}
+
+		results = append(results, sarifResult{
+			RuleID:    kind(leak),
+			RuleIndex: ruleIndex,
+			Level:     "warning",
+			Message:   sarifMessage{Text: leak.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifact{
+						URI:       relativeFile(repository, leak),
+						URIBaseID: "SRCROOT",
+					},
+					Region: sarifRegion{
+						StartLine:   leak.Line,
+						StartColumn: leak.Column,
+					},
+				},
+			}},
+		})
+	}
+
+	return sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "depbleed",
+			InformationURI: "https://depbleed.io",
+			Rules:          sarifRules,
+		}},
+		Results: results,
+		VersionControlProvenance: []sarifVersionControl{{
+			RepositoryURI: repositoryURI,
+			RevisionID:    analysis.Hash,
+		}},
+		OriginalURIBaseIDs: map[string]sarifArtifact{
+			"SRCROOT": {URI: repositoryURI + "/blob/" + analysis.Hash + "/"},
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+func TestSARIF(t *testing.T) {
+	repository := persistence.Repository{URL: "github.com/user/repo"}
+	analysis := &persistence.Analysis{
+		Hash: "abc",
+		Leaks: []*persistence.Leak{
+			{File: "user/repo/a/a.go", Line: 3, Column: 6, Message: "F: function result 0 is an external type: x.T is a vendorized type from user/repo/vendor/x", Kind: persistence.LeakVendored},
+			{File: "user/repo/b.go", Line: 7, Column: 2, Message: "G: function result 0 is an external type: y.T is a global type from example.com/y"},
+		},
+	}
+
+	body, err := SARIF(repository, analysis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(body, &log); err != nil {
+		t.Fatal(err)
+	}
+
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("expected one SARIF 2.1.0 run; got %s", body)
+	}
+	run := log.Runs[0]
+
+	if run.VersionControlProvenance[0].RepositoryURI != "https://github.com/user/repo" || run.VersionControlProvenance[0].RevisionID != "abc" {
+		t.Errorf("unexpected provenance %+v", run.VersionControlProvenance)
+	}
+
+	testCases := []struct {
+		RuleID string
+		URI    string
+		Line   int
+	}{
+		{RuleID: "vendored", URI: "a/a.go", Line: 3},
+		{RuleID: "global", URI: "b.go", Line: 7},
+	}
+
+	for i, testCase := range testCases {
+		result := run.Results[i]
+		location := result.Locations[0].PhysicalLocation
+
+		if result.RuleID != testCase.RuleID || run.Tool.Driver.Rules[result.RuleIndex].ID != testCase.RuleID {
+			t.Errorf("expected rule %s; got %s (index %d)", testCase.RuleID, result.RuleID, result.RuleIndex)
+		}
+		if location.ArtifactLocation.URI != testCase.URI || location.Region.StartLine != testCase.Line {
+			t.Errorf("expected location %s:%d; got %s:%d", testCase.URI, testCase.Line, location.ArtifactLocation.URI, location.Region.StartLine)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/depbleed/backend/logger"
+	"github.com/depbleed/backend/persistence"
+)
+
+//Prune deletes from dao what retention doesn't keep every interval until
+//stopping is done. The returned channel is closed once it returned.
+func Prune(stopping context.Context, interval time.Duration, dao persistence.DAO, retention persistence.Retention) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			wait(stopping, interval)
+			if stopping.Err() != nil {
+				return
+			}
+			prune(stopping, dao, retention)
+		}
+	}()
+	return done
+}
+
+//prune deletes from dao what retention doesn't keep once, and logs it. It
+//is interrupted when stopping is done; the next run deletes the rest.
+func prune(stopping context.Context, dao persistence.DAO, retention persistence.Retention) {
+	start := time.Now()
+	prunings, err := persistence.Prune(stopping, dao, retention, start, false)
+
+	repositories, analyses := 0, 0
+	for _, pruning := range prunings {
+		if pruning.Repository {
+			repositories++
+		}
+		analyses += len(pruning.Hashes)
+	}
+
+	if err != nil && stopping.Err() == nil {
+		logger.Error("Can't prune", "err", err, "repositories", repositories, "analyses", analyses)
+		return
+	}
+	logger.Info("pruned",
+		"repositories", repositories,
+		"analyses", analyses,
+		"duration", time.Since(start),
+	)
+}
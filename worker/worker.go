@@ -0,0 +1,188 @@
+//Package worker runs the analysis jobs of the queue
+package worker
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/depbleed/backend/analyzer"
+	"github.com/depbleed/backend/logger"
+	"github.com/depbleed/backend/persistence"
+)
+
+const (
+	//lease is how long a job stays claimed without being renewed
+	lease = time.Minute
+	//pollInterval is how long the worker waits when there is no job
+	pollInterval = 5 * time.Second
+	//operationTimeout bounds each call to the store
+	operationTimeout = 30 * time.Second
+)
+
+//Worker claims the jobs of Queue one at a time and stores their analyses
+type Worker struct {
+	ID          string
+	Persistence persistence.DAO
+	Queue       persistence.Queue
+	//Analyse computes the analysis of commit of repo (user/repo) in
+	//language, or in the language it detects when it is empty, and returns
+	//the language analysed
+	Analyse func(ctx context.Context, repo string, language string, commit string) (string, *persistence.Analysis, error)
+	//DrainTimeout is how long the running job has to complete once the
+	//worker is stopping; it is queued again afterwards
+	DrainTimeout time.Duration
+}
+
+//Start runs count copies of w, named after w.ID, until stopping is done.
+//The returned channel is closed once they all returned.
+func Start(stopping context.Context, count int, w Worker) <-chan struct{} {
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		copy := w
+		copy.ID = w.ID + "-" + strconv.Itoa(i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Info("Waiting for jobs", "worker", copy.ID)
+			copy.Serve(stopping)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+//Serve claims and runs jobs until stopping is done
+func (w *Worker) Serve(stopping context.Context) {
+	for stopping.Err() == nil {
+		ctx, cancel := operation()
+		job, err := w.Queue.ClaimJob(ctx, w.ID, lease)
+		cancel()
+
+		if err == persistence.ErrNoJob {
+			wait(stopping, pollInterval)
+			continue
+		} else if err != nil {
+			logger.Error("Can't claim job", "worker", w.ID, "err", err)
+			wait(stopping, pollInterval)
+			continue
+		}
+
+		w.run(stopping, job)
+	}
+}
+
+//operation returns the context of a call to the store. The calls aren't
+//interrupted when the worker stops so that the jobs are left consistent.
+func operation() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), operationTimeout)
+}
+
+//wait waits for d unless stopping is done first
+func wait(stopping context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-stopping.Done():
+	case <-timer.C:
+	}
+}
+
+//run runs a claimed job while renewing its lease. Once stopping is done,
+//the job has DrainTimeout to complete before it is interrupted and queued
+//again.
+func (w *Worker) run(stopping context.Context, job *persistence.Job) {
+	start := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var interrupted int32
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopping.Done():
+			wait(ctx, w.DrainTimeout)
+			if ctx.Err() == nil {
+				atomic.StoreInt32(&interrupted, 1)
+				cancel()
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				renewing, cancelRenew := operation()
+				err := w.Queue.RenewJob(renewing, job, lease)
+				cancelRenew()
+				if err != nil {
+					//Another worker took over the job
+					logger.Warn("Can't renew job", "job", job.ID, "err", err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	//The jobs queued before the languages are Go ones
+	language := job.Language
+	if language == "" {
+		language = analyzer.Go
+	}
+	language, analysis, err := w.Analyse(ctx, job.Repo, language, job.Commit)
+
+	if atomic.LoadInt32(&interrupted) == 1 {
+		//Another worker runs it from scratch
+		releasing, cancelRelease := operation()
+		defer cancelRelease()
+		if err := w.Queue.ReleaseJob(releasing, job); err != nil {
+			logger.Error("Can't release job", "job", job.ID, "err", err)
+		}
+		logger.Warn("job interrupted",
+			"job", job.ID,
+			"repo", job.Repo,
+			"commit", job.Commit,
+			"duration", time.Since(start),
+		)
+		return
+	}
+
+	storing, cancelStore := operation()
+	defer cancelStore()
+
+	if err == nil {
+		persisting := time.Now()
+		_, err = w.Persistence.AppendAnalysis(storing, "github.com/"+job.Repo, language, analysis)
+		analyzer.PhaseDuration.WithLabelValues("persist").Observe(time.Since(persisting).Seconds())
+	}
+
+	if err := w.Queue.CompleteJob(storing, job, err); err != nil {
+		logger.Error("Can't complete job", "job", job.ID, "err", err)
+	}
+
+	logger.Info("job",
+		"job", job.ID,
+		"repo", job.Repo,
+		"language", language,
+		"commit", job.Commit,
+		"status", job.Status,
+		"err", job.Error,
+		"duration", time.Since(start),
+	)
+}
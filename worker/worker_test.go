@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/depbleed/backend/persistence"
+)
+
+type mockQueue struct {
+	completed []string
+	released  []string
+}
+
+func (q *mockQueue) EnqueueJob(ctx context.Context, repo string, language string, commit string) error {
+	return nil
+}
+
+func (q *mockQueue) ClaimJob(ctx context.Context, worker string, lease time.Duration) (*persistence.Job, error) {
+	return nil, persistence.ErrNoJob
+}
+
+func (q *mockQueue) RenewJob(ctx context.Context, job *persistence.Job, lease time.Duration) error {
+	return nil
+}
+
+func (q *mockQueue) CompleteJob(ctx context.Context, job *persistence.Job, err error) error {
+	q.completed = append(q.completed, job.ID)
+	return nil
+}
+
+func (q *mockQueue) ReleaseJob(ctx context.Context, job *persistence.Job) error {
+	q.released = append(q.released, job.ID)
+	return nil
+}
+
+func (q *mockQueue) CountJobs(ctx context.Context, status string) (int, error) {
+	return 0, nil
+}
+
+func TestRunDrain(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		duration time.Duration
+		released bool
+	}{
+		{"completes within the deadline", 10 * time.Millisecond, false},
+		{"interrupted after the deadline", time.Hour, true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			queue := &mockQueue{}
+			w := &Worker{
+				Persistence: persistence.NewMemory(),
+				Queue:       queue,
+				Analyse: func(ctx context.Context, repo string, language string, commit string) (string, *persistence.Analysis, error) {
+					select {
+					case <-ctx.Done():
+						return "", nil, ctx.Err()
+					case <-time.After(testCase.duration):
+						return language, &persistence.Analysis{Hash: commit}, nil
+					}
+				},
+				DrainTimeout: 50 * time.Millisecond,
+			}
+
+			stopping, stop := context.WithCancel(context.Background())
+			stop()
+			w.run(stopping, &persistence.Job{ID: "1", Repo: "depbleed/go", Language: "GO", Commit: "a"})
+
+			if released := len(queue.released) == 1; released != testCase.released {
+				t.Errorf("expected released %v; got %v", testCase.released, released)
+			}
+			if completed := len(queue.completed) == 1; completed == testCase.released {
+				t.Errorf("expected completed %v; got %v", !testCase.released, completed)
+			}
+		})
+	}
+}
+
+func TestServeStops(t *testing.T) {
+
+	w := &Worker{Queue: &mockQueue{}}
+	stopping, stop := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		w.Serve(stopping)
+		close(done)
+	}()
+
+	stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("expected the worker to stop polling")
+	}
+}
+
+func TestStore(t *testing.T) {
+	dao := persistence.NewMemory()
+	queue := &mockQueue{}
+	w := &Worker{
+		Persistence: dao,
+		Queue:       queue,
+		Analyse: func(ctx context.Context, repo string, language string, commit string) (string, *persistence.Analysis, error) {
+			return language, &persistence.Analysis{Hash: commit, Grade: commit}, nil
+		},
+	}
+
+	for i, commit := range []string{"a", "b", "b"} {
+		w.run(context.Background(), &persistence.Job{ID: fmt.Sprint(i), Repo: "depbleed/go", Language: "GO", Commit: commit})
+	}
+	if len(queue.completed) != 3 {
+		t.Errorf("expected 3 completed jobs; got %d", len(queue.completed))
+	}
+
+	repository, err := dao.FindRepo(context.Background(), "github.com/depbleed/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repository.Language != "GO" {
+		t.Errorf("expected the repository to be stored in the language analysed; got %q", repository.Language)
+	}
+	if len(repository.Analysis) != 2 {
+		t.Errorf("expected 2 analyses; got %d", len(repository.Analysis))
+	}
+	if repository.Latest().Hash != "b" {
+		t.Errorf("expected latest analysis to be b; got %s", repository.Latest().Hash)
+	}
+	if repository.Grade != "b" {
+		t.Errorf("expected the grade of the latest analysis; got %s", repository.Grade)
+	}
+}
+
+func TestStoreWithoutLanguage(t *testing.T) {
+	dao := persistence.NewMemory()
+	//Stored by a job without a language before they were defaulted
+	if _, err := dao.AppendAnalysis(context.Background(), "github.com/depbleed/old", "", &persistence.Analysis{Hash: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	w := &Worker{
+		Persistence: dao,
+		Queue:       &mockQueue{},
+		Analyse: func(ctx context.Context, repo string, language string, commit string) (string, *persistence.Analysis, error) {
+			//Like the refusals of the clones, in the language of the job
+			return language, &persistence.Analysis{Hash: commit, Status: persistence.StatusRefused}, nil
+		},
+	}
+
+	for _, repo := range []string{"depbleed/go", "depbleed/old"} {
+		w.run(context.Background(), &persistence.Job{ID: repo, Repo: repo, Commit: "b"})
+
+		repository, err := dao.FindRepo(context.Background(), "github.com/"+repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repository.Language != "GO" {
+			t.Errorf("expected %s to be stored as GO; got %q", repo, repository.Language)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dao := persistence.NewMemory()
+	old := &persistence.Analysis{Hash: "a", Time: time.Now().AddDate(-2, 0, 0).Unix()}
+	if _, err := dao.AppendAnalysis(context.Background(), "github.com/depbleed/go", "GO", old); err != nil {
+		t.Fatal(err)
+	}
+
+	stopping, stop := context.WithCancel(context.Background())
+	pruned := Prune(stopping, 10*time.Millisecond, dao, persistence.Retention{Unrequested: time.Hour})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := dao.FindRepo(context.Background(), "github.com/depbleed/go")
+		if err == persistence.ErrNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the repository to be pruned; got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stop()
+	select {
+	case <-pruned:
+	case <-time.After(time.Second):
+		t.Errorf("expected the pruning to stop")
+	}
+}